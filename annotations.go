@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Annotation prefix used unless --annotation-prefix is set
+const defaultAnnotationPrefix = "reboot-agent.v1.sdlt.local"
+
+// AnnotationKeys holds the annotation keys the agent reads and writes, all under one prefix so
+// several agents with different prefixes can share a cluster
+type AnnotationKeys struct {
+	Reboot           string
+	RebootNeeded     string
+	RebootInProgress string
+	RebootID         string
+	NoReboot         string
+	CordonedByAgent  string
+	LastReboot       string
+	// Where earlier versions recorded the last reboot. Still read, and removed when the next
+	// reboot completes, so nodes don't lose their history on upgrade.
+	LegacyLastReboot string
+	BootID           string
+	RebootReason     string
+	// Free text going with the reason code in RebootReason
+	RebootReasonDetail string
+	RebootCount        string
+	DrainTimeout       string
+	RebootRequestedAt  string
+}
+
+// newAnnotationKeys derives the annotation keys from a prefix, which must be a DNS subdomain
+func newAnnotationKeys(prefix string) (AnnotationKeys, error) {
+	if errs := validation.IsDNS1123Subdomain(prefix); len(errs) > 0 {
+		return AnnotationKeys{}, fmt.Errorf("invalid annotation prefix %q: %s", prefix, strings.Join(errs, "; "))
+	}
+	return AnnotationKeys{
+		Reboot:             prefix + "/reboot",
+		RebootNeeded:       prefix + "/reboot-needed",
+		RebootInProgress:   prefix + "/reboot-in-progress",
+		RebootID:           prefix + "/reboot-id",
+		NoReboot:           prefix + "/no-reboot",
+		CordonedByAgent:    prefix + "/cordoned",
+		LastReboot:         prefix + "/last-rebooted",
+		LegacyLastReboot:   prefix + "/last-reboot",
+		BootID:             prefix + "/boot-id",
+		RebootReason:       prefix + "/reboot-reason",
+		RebootReasonDetail: prefix + "/reboot-reason-detail",
+		RebootCount:        prefix + "/reboot-count",
+		DrainTimeout:       prefix + "/drain-timeout",
+		RebootRequestedAt:  prefix + "/reboot-requested-at",
+	}, nil
+}
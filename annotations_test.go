@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCustomAnnotationPrefix(t *testing.T) {
+	keys, err := newAnnotationKeys("reboots.example.com")
+	if err != nil {
+		t.Fatalf("newAnnotationKeys() failed: %v", err)
+	}
+	if keys.Reboot != "reboots.example.com/reboot" || keys.RebootInProgress != "reboots.example.com/reboot-in-progress" {
+		t.Errorf("keys = %+v, want them under reboots.example.com", keys)
+	}
+
+	// Another agent's annotations are left alone
+	other := testNode("node-1", map[string]string{testKeys(t).Reboot: ""})
+	if got := shouldReboot(discardLogger(), other, keys, nil, time.Now(), newRebootLimiter(1)); got.reboot {
+		t.Errorf("reboot under the default prefix started a reboot: %+v", got)
+	}
+	own := testNode("node-1", map[string]string{keys.Reboot: ""})
+	if got := shouldReboot(discardLogger(), own, keys, nil, time.Now(), newRebootLimiter(1)); !got.reboot {
+		t.Errorf("reboot under the custom prefix ignored: %+v", got)
+	}
+
+	// The update logic writes under the custom prefix
+	config := testControllerConfig(t)
+	config.keys = keys
+	c, client := newTestControllerWithConfig(t, config, own)
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatalf("syncNode() failed: %v", err)
+	}
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rebootInProgress(node, keys) {
+		t.Errorf("reboot not started under the custom prefix, annotations %v", node.Annotations)
+	}
+	for key := range node.Annotations {
+		if !strings.HasPrefix(key, "reboots.example.com/") {
+			t.Errorf("wrote %s outside the custom prefix", key)
+		}
+	}
+}
+
+func TestInvalidAnnotationPrefix(t *testing.T) {
+	for _, prefix := range []string{"", "Reboots.Example.com", "reboots/example", "-reboots.example.com"} {
+		if _, err := newAnnotationKeys(prefix); err == nil {
+			t.Errorf("newAnnotationKeys(%q) succeeded, want an error", prefix)
+		}
+	}
+}
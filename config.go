@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings a --config file can give. Each field is named after the flag it
+// sets; fields left out of the file leave the flag alone.
+type Config struct {
+	Mode                    *string        `yaml:"mode"`
+	LocalRebootMethod       *string        `yaml:"local-reboot-method"`
+	Kubeconfig              *string        `yaml:"kubeconfig"`
+	Context                 *string        `yaml:"context"`
+	CacheSyncTimeout        *time.Duration `yaml:"cache-sync-timeout"`
+	ShutdownTimeout         *time.Duration `yaml:"shutdown-timeout"`
+	Namespace               *string        `yaml:"namespace"`
+	NodeLabelSelector       *string        `yaml:"node-label-selector"`
+	ResyncPeriod            *time.Duration `yaml:"resync-period"`
+	AnnotationPrefix        *string        `yaml:"annotation-prefix"`
+	AnnotationConfigMap     *string        `yaml:"annotation-configmap"`
+	Workers                 *int           `yaml:"workers"`
+	RestartConcurrency      *int           `yaml:"restart-concurrency"`
+	MaxConcurrentReboots    *int           `yaml:"max-concurrent-reboots"`
+	APITimeout              *time.Duration `yaml:"api-timeout"`
+	ConflictRetries         *int           `yaml:"conflict-retries"`
+	ConflictBackoff         *time.Duration `yaml:"conflict-backoff"`
+	RequeueBaseDelay        *time.Duration `yaml:"requeue-base-delay"`
+	RequeueMaxDelay         *time.Duration `yaml:"requeue-max-delay"`
+	RequeueMaxRetries       *int           `yaml:"requeue-max-retries"`
+	DryRun                  *bool          `yaml:"dry-run"`
+	RebootWindow            *string        `yaml:"reboot-window"`
+	RebootWindowTimezone    *string        `yaml:"reboot-window-timezone"`
+	DrainTimeout            *time.Duration `yaml:"drain-timeout"`
+	DrainForce              *bool          `yaml:"drain-force"`
+	MaxTotalDisruptions     *int           `yaml:"max-total-disruptions"`
+	MaxConcurrentDrains     *int           `yaml:"max-concurrent-drains"`
+	MaintenanceGroupLabel   *string        `yaml:"maintenance-group-label"`
+	DrainIncludeNamespaces  *string        `yaml:"drain-include-namespaces"`
+	DrainExcludeNamespaces  *string        `yaml:"drain-exclude-namespaces"`
+	FailOnUndrainable       *bool          `yaml:"fail-on-undrainable"`
+	WaitForReschedule       *bool          `yaml:"wait-for-reschedule"`
+	RescheduleTimeout       *time.Duration `yaml:"reschedule-timeout"`
+	FastPathEmptyNodes      *bool          `yaml:"fast-path-empty-nodes"`
+	RebootTaint             *string        `yaml:"reboot-taint"`
+	RebootStuckTimeout      *time.Duration `yaml:"reboot-stuck-timeout"`
+	RestartCooldown         *time.Duration `yaml:"restart-cooldown"`
+	OwnerMaxDepth           *int           `yaml:"owner-max-depth"`
+	WaitForRollout          *bool          `yaml:"wait-for-rollout"`
+	RolloutTimeout          *time.Duration `yaml:"rollout-timeout"`
+	MetricsAddr             *string        `yaml:"metrics-addr"`
+	EnablePartialDrain      *bool          `yaml:"enable-partial-drain"`
+	MetricsSampleInterval   *time.Duration `yaml:"metrics-sample-interval"`
+	HealthAddr              *string        `yaml:"health-addr"`
+	FailOnMetricsBindError  *bool          `yaml:"fail-on-metrics-bind-error"`
+	WatchErrorThreshold     *int           `yaml:"watch-error-threshold"`
+	WatchErrorWindow        *time.Duration `yaml:"watch-error-window"`
+	VerifyBackends          *bool          `yaml:"verify-backends"`
+	Once                    *bool          `yaml:"once"`
+	EnableRebootRequests    *bool          `yaml:"enable-reboot-requests"`
+	EnableLeaderElection    *bool          `yaml:"enable-leader-election"`
+	LeaderElectionNamespace *string        `yaml:"leader-election-namespace"`
+	LeaderElectionID        *string        `yaml:"leader-election-id"`
+	NotifyWebhookURL        *string        `yaml:"notify-webhook-url"`
+	NotifyMinSeverity       *string        `yaml:"notify-min-severity"`
+	SSHUser                 *string        `yaml:"ssh-user"`
+	SSHKeyPath              *string        `yaml:"ssh-key-path"`
+	SSHKnownHosts           *string        `yaml:"ssh-known-hosts"`
+	SSHPort                 *int           `yaml:"ssh-port"`
+	RebootCommand           *string        `yaml:"reboot-command"`
+	LogLevel                *string        `yaml:"log-level"`
+	LogFormat               *string        `yaml:"log-format"`
+
+	// Restart conventions of rollout CRDs besides the built-in ones. Only settable in the file.
+	RolloutRestarts []RolloutRestartConfig `yaml:"rollout-restarts" flag:"-"`
+}
+
+// RolloutRestartConfig tells the agent how to restart a rollout CR that owns ReplicaSets in
+// place of a Deployment: the resource to patch and the dot-separated path of the field to set
+// to the current time, e.g. spec.restartAt
+type RolloutRestartConfig struct {
+	Group     string `yaml:"group"`
+	Kind      string `yaml:"kind"`
+	Resource  string `yaml:"resource"`
+	FieldPath string `yaml:"fieldPath"`
+}
+
+// LoadConfig reads a YAML config file. Keys that aren't flags are an error, so typos don't go
+// unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	var config Config
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// apply sets the flags given in the config file, except those set on the command line, which
+// take precedence. Settings that aren't flags are left for the caller.
+func (c *Config) apply(flags *flag.FlagSet) error {
+	setOnCommandLine := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		tag := value.Type().Field(i).Tag
+		name := tag.Get("yaml")
+		field := value.Field(i)
+		if tag.Get("flag") == "-" || field.IsNil() || setOnCommandLine[name] {
+			continue
+		}
+		if err := flags.Set(name, fmt.Sprint(field.Elem().Interface())); err != nil {
+			return fmt.Errorf("invalid %s in config file: %w", name, err)
+		}
+	}
+	return nil
+}
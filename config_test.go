@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helper function to build a flag set with a few of the agent's flags
+func testFlagSet() (*flag.FlagSet, *int, *time.Duration, *bool) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	workers := flags.Int("workers", 1, "")
+	drainTimeout := flags.Duration("drain-timeout", 5*time.Minute, "")
+	dryRun := flags.Bool("dry-run", false, "")
+	return flags, workers, drainTimeout, dryRun
+}
+
+func TestConfigFileSetsFlags(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, "workers: 3\ndrain-timeout: 10m\ndry-run: true\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+
+	// Only in the file
+	flags, workers, drainTimeout, dryRun := testFlagSet()
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.apply(flags); err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+	if *workers != 3 || *drainTimeout != 10*time.Minute || !*dryRun {
+		t.Errorf("workers = %d, drain-timeout = %v, dry-run = %v; want the file's 3, 10m, true", *workers, *drainTimeout, *dryRun)
+	}
+
+	// The command line wins over the file, which still sets the rest
+	flags, workers, drainTimeout, _ = testFlagSet()
+	if err := flags.Parse([]string{"--workers=5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.apply(flags); err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+	if *workers != 5 || *drainTimeout != 10*time.Minute {
+		t.Errorf("workers = %d, drain-timeout = %v; want 5 from the command line and 10m from the file", *workers, *drainTimeout)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown key", "workers: 3\ndrian-timeout: 10m\n", "drian-timeout"},
+		{"invalid value", "workers: many\n", "many"},
+		{"invalid duration", "drain-timeout: soon\n", "soon"},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfig(writeConfig(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: LoadConfig() error = %v, want one mentioning %q", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "")); err != nil {
+		t.Errorf("LoadConfig() of an empty file failed: %v", err)
+	}
+	if _, err := LoadConfig(t.TempDir() + "/missing.yaml"); err == nil {
+		t.Error("LoadConfig() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Controller moves reboot handling out of the informer callbacks: event handlers only enqueue
+// object keys, and workers dequeue them, read the object from the lister and run the reboot
+// logic, requeueing with rate limiting on error.
+type Controller struct {
+	controllerConfig
+	logger        *slog.Logger
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	recorder      record.EventRecorder
+
+	nodeLister corelisters.NodeLister
+	podLister  corelisters.PodLister
+	nodeQueue  workqueue.TypedRateLimitingInterface[string]
+	podQueue   workqueue.TypedRateLimitingInterface[string]
+
+	// Only set once EnableRebootRequests has been called
+	rebootRequestLister dynamiclister.Lister
+	rebootRequestQueue  workqueue.TypedRateLimitingInterface[string]
+
+	// Tracks running workers so shutdown can wait for in-flight items
+	workers sync.WaitGroup
+
+	// Consecutive failures per queued key, to drop keys after requeueBackoff.Steps. Kept apart
+	// from the queues' own requeue counts, which also count waits for a slot or window.
+	failuresMu sync.Mutex
+	failures   map[failureKey]int
+
+	// When each node waiting for a reboot to start was first seen waiting, for the oldest
+	// pending reboot gauge
+	pendingMu    sync.Mutex
+	pendingSince map[string]time.Time
+
+	// Guards keys, whose request keys an annotation ConfigMap can change while running
+	keysMu sync.RWMutex
+
+	// Only set once RebootResults has been called
+	results *rebootResults
+
+	// Last reboot failure per node, for the RebootRequests waiting on it
+	nodeFailuresMu sync.Mutex
+	nodeFailures   map[string]nodeFailure
+}
+
+// controllerConfig holds the settings the reboot and restart flows run with, mostly from the
+// command line
+type controllerConfig struct {
+	keys            AnnotationKeys
+	rebootLimiter   *rebootLimiter
+	decisions       *decisionLog
+	rebootWindow    *MaintenanceWindow
+	drainTimeout    time.Duration
+	drainForce      bool
+	drainFilter     drainFilter
+	drainLimiter    *drainLimiter
+	evictions       *evictionAPI
+	rescheduleWait  time.Duration // 0 doesn't wait for evicted pods to be rescheduled
+	fastPathEmpty   bool
+	rebootTaint     *v1.Taint
+	stuckTimeout    time.Duration
+	rebooter        Rebooter
+	restartCooldown *restartCooldown
+	disruptions     *disruptionBudget // Shared with rebootLimiter
+	ownerMaxDepth   int
+	rolloutTimeout  time.Duration // 0 doesn't wait for Deployment rollouts
+	apiTimeout      time.Duration
+	conflictBackoff wait.Backoff
+	requeueBackoff  wait.Backoff
+	dryRun          bool
+}
+
+type failureKey struct {
+	queue workqueue.TypedRateLimitingInterface[string]
+	key   string
+}
+
+// NewController creates a Controller and registers its event handlers on the node and pod
+// informers. The informers must not have been started yet.
+func NewController(logger *slog.Logger, clientset kubernetes.Interface, dynamicClient dynamic.Interface, recorder record.EventRecorder, nodeInformer cache.SharedIndexInformer, podInformer cache.SharedIndexInformer, config controllerConfig) (*Controller, error) {
+	c := &Controller{
+		controllerConfig: config,
+		logger:           logger,
+		clientset:        clientset,
+		dynamicClient:    dynamicClient,
+		recorder:         recorder,
+		nodeLister:       corelisters.NewNodeLister(nodeInformer.GetIndexer()),
+		podLister:        corelisters.NewPodLister(podInformer.GetIndexer()),
+		podQueue: workqueue.NewTypedRateLimitingQueueWithConfig(newRequeueRateLimiter(config.requeueBackoff),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "pods"}),
+		failures:     make(map[failureKey]int),
+		pendingSince: make(map[string]time.Time),
+	}
+	// Nodes come off the queue by reboot priority rather than in arrival order
+	c.nodeQueue = workqueue.NewTypedRateLimitingQueueWithConfig(newRequeueRateLimiter(config.requeueBackoff),
+		workqueue.TypedRateLimitingQueueConfig[string]{
+			Name: "nodes",
+			DelayingQueue: workqueue.NewTypedDelayingQueueWithConfig(workqueue.TypedDelayingQueueConfig[string]{
+				Name:  "nodes",
+				Queue: workqueue.NewTypedWithConfig(workqueue.TypedQueueConfig[string]{Name: "nodes", Queue: newNodePriorityQueue(c.nodeRebootPriority)}),
+			}),
+		})
+
+	// Define event handlers for pod informer. Only pods carrying a reboot annotation reach them;
+	// a pod gaining one is seen as an add and one losing them all as a delete.
+	_, err := podInformer.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			return podHasRebootAnnotation(obj, c.annotationKeys())
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				pod := obj.(*v1.Pod)
+				logger.Debug("Pod added", "pod", pod.Name, "namespace", pod.Namespace)
+				c.enqueue(c.podQueue, obj)
+			},
+			UpdateFunc: c.podUpdated,
+			DeleteFunc: func(obj interface{}) {
+				pod, ok := obj.(*v1.Pod)
+				if !ok {
+					return // Tombstone for a pod deleted while the watch was down
+				}
+				logger.Debug("Pod deleted", "pod", pod.Name, "namespace", pod.Namespace)
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add pod event handler: %w", err)
+	}
+
+	// Define event handlers for node informer
+	_, err = nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			node := obj.(*v1.Node)
+			logger.Debug("Node added", "node", node.Name)
+			c.enqueue(c.nodeQueue, obj)
+		},
+		UpdateFunc: c.nodeUpdated,
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj // Deleted while the watch was down
+			}
+			node, ok := obj.(*v1.Node)
+			if !ok {
+				return
+			}
+			logger.Debug("Node deleted", "node", node.Name)
+			// A deleted node never clears its in-progress annotation, free its slot
+			c.rebootLimiter.release(node.Name)
+			c.updatePendingReboot(node.Name, false, time.Now())
+			c.decisions.forget(node.Name)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %w", err)
+	}
+
+	return c, nil
+}
+
+// Update handler for the pod informer, which only sees pods carrying a reboot annotation
+func (c *Controller) podUpdated(oldObj, newObj interface{}) {
+	oldPod := oldObj.(*v1.Pod)
+	newPod := newObj.(*v1.Pod)
+
+	// A resync re-evaluates the pod, retrying a restart that was dropped; the cooldown keeps it
+	// from restarting the workload again
+	if !resourceVersionChanged(oldPod, newPod) {
+		c.logger.Debug("Resyncing pod", "pod", newPod.Name, "namespace", newPod.Namespace)
+		c.enqueue(c.podQueue, newObj)
+		return
+	}
+	// Skip updates (status, unrelated annotations) that can't affect reboots
+	if !rebootAnnotationsChanged(oldPod.Annotations, newPod.Annotations, c.annotationKeys()) {
+		return
+	}
+	c.logger.Debug("Reboot annotations updated on pod", "pod", newPod.Name, "namespace", newPod.Namespace, "annotations", newPod.Annotations)
+	c.enqueue(c.podQueue, newObj)
+}
+
+// Update handler for the node informer
+func (c *Controller) nodeUpdated(oldObj, newObj interface{}) {
+	oldNode := oldObj.(*v1.Node)
+	newNode := newObj.(*v1.Node)
+
+	// A resync re-evaluates nodes somewhere in the reboot flow, retrying whatever was dropped or
+	// missed; idle nodes have nothing to retry
+	if !resourceVersionChanged(oldNode, newNode) {
+		if c.nodeInRebootFlow(newNode) {
+			c.logger.Debug("Resyncing node", "node", newNode.Name)
+			c.enqueue(c.nodeQueue, newObj)
+		}
+		return
+	}
+
+	// Skip status-only updates (heartbeats) unless they show the node may have rebooted,
+	// which is what completes a reboot in progress
+	rebooted := bootIDChanged(oldNode, newNode) || readyChanged(oldNode, newNode)
+	if !rebooted && statusOnlyChange(oldNode.Annotations, newNode.Annotations, oldNode.Spec, newNode.Spec) {
+		return
+	}
+	c.logger.Debug("Node updated", "node", newNode.Name)
+
+	// Check for changes in annotations
+	if rebooted || !equalAnnotations(oldNode.Annotations, newNode.Annotations) {
+		c.logger.Debug("Annotations or boot state updated on node", "node", newNode.Name, "annotations", newNode.Annotations)
+		c.enqueue(c.nodeQueue, newObj)
+	}
+}
+
+// Helper function to get the annotation keys in use. Callers handling one object take them once,
+// so a key change from the annotation ConfigMap doesn't land halfway through.
+func (c *Controller) annotationKeys() AnnotationKeys {
+	c.keysMu.RLock()
+	defer c.keysMu.RUnlock()
+	return c.keys
+}
+
+// Helper function to check whether the node has anything for the reboot flow to act on: a
+// requested or running reboot, or a cordon or taint left to undo
+func (c *Controller) nodeInRebootFlow(node *v1.Node) bool {
+	keys := c.annotationKeys()
+	for _, key := range []string{keys.Reboot, keys.RebootNeeded, keys.RebootInProgress, keys.CordonedByAgent} {
+		if _, exists := node.Annotations[key]; exists {
+			return true
+		}
+	}
+	return c.rebootTaint != nil && hasTaint(node, c.rebootTaint)
+}
+
+// Start launches workers for the node (and RebootRequest) queues, and podWorkers for the pod
+// queue, then returns. The queues are shut down when stopCh closes; use Wait to block until
+// the workers have returned.
+func (c *Controller) Start(ctx context.Context, workers, podWorkers int, stopCh <-chan struct{}) {
+	c.seedRebootLimiter()
+	for i := 0; i < workers; i++ {
+		c.runWorker(ctx, c.nodeQueue, c.syncNode)
+		if c.rebootRequestQueue != nil {
+			c.runWorker(ctx, c.rebootRequestQueue, c.syncRebootRequest)
+		}
+	}
+	// Pod workers run workload restarts, which restartCooldown serializes per workload
+	for i := 0; i < podWorkers; i++ {
+		c.runWorker(ctx, c.podQueue, c.syncPod)
+	}
+
+	// Drain rather than drop the queues on stop, so in-flight items finish and release their
+	// reboot slots and workload locks. The drains count as workers, so Wait bounds them too.
+	queues := []workqueue.TypedRateLimitingInterface[string]{c.nodeQueue, c.podQueue}
+	if c.rebootRequestQueue != nil {
+		queues = append(queues, c.rebootRequestQueue)
+	}
+	c.workers.Add(len(queues))
+	go func() {
+		<-stopCh
+		for _, queue := range queues {
+			go func() {
+				defer c.workers.Done()
+				queue.ShutDownWithDrain()
+			}()
+		}
+	}()
+}
+
+// Wait blocks until all workers have returned and the queues have drained, or the timeout
+// elapses. Reports whether the workers returned.
+func (c *Controller) Wait(timeout time.Duration) bool {
+	return waitWithTimeout(&c.workers, timeout)
+}
+
+// Helper function to give the reboot slots to the cached nodes already rebooting, e.g. started
+// by a previous leader, before any worker can start another reboot
+func (c *Controller) seedRebootLimiter() {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error("Failed to list nodes to seed the reboot limiter", "error", err)
+		return
+	}
+	for _, node := range nodes {
+		if rebootInProgress(node, c.annotationKeys()) {
+			c.logger.Info("Node already rebooting, holding its reboot slot", "node", node.Name)
+			c.rebootLimiter.hold(node.Name, c.rebootLimiter.group(node))
+		}
+	}
+}
+
+// Helper function to start a worker goroutine processing queue until it shuts down
+func (c *Controller) runWorker(ctx context.Context, queue workqueue.TypedRateLimitingInterface[string], sync func(context.Context, string) error) {
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		defer utilruntime.HandleCrash()
+		for c.processNextItem(ctx, queue, sync) {
+		}
+	}()
+}
+
+// Returned by sync functions to put a key back on the queue with rate limiting without
+// treating it as a failure, e.g. while a node waits for a free reboot slot
+type requeueError struct {
+	reason string
+}
+
+func (e *requeueError) Error() string {
+	return "requeue: " + e.reason
+}
+
+// processNextItem handles one key from the queue. On error the key is requeued with rate
+// limiting; on success its rate limiting history is forgotten. Keys are dropped unhandled once
+// ctx is done. Returns false once the queue has shut down.
+func (c *Controller) processNextItem(ctx context.Context, queue workqueue.TypedRateLimitingInterface[string], sync func(context.Context, string) error) bool {
+	key, quit := queue.Get()
+	if quit {
+		return false
+	}
+	defer queue.Done(key)
+	if queue == c.nodeQueue {
+		queueDepth.Set(float64(queue.Len()))
+	}
+
+	// Leadership was lost: whatever the key asks for is left to the next leader. Keys still
+	// queued when shutdown begins are handled, within the shutdown grace period.
+	if ctx.Err() != nil {
+		queue.Forget(key)
+		return true
+	}
+
+	err := sync(ctx, key)
+	var requeue *requeueError
+	if errors.As(err, &requeue) {
+		c.logger.Debug("Requeueing key", "key", key, "reason", requeue.reason)
+		queue.AddRateLimited(key)
+		return true
+	}
+	if err != nil {
+		failures := c.recordFailure(queue, key)
+		if c.requeueBackoff.Steps > 0 && failures >= c.requeueBackoff.Steps {
+			c.logger.Error("Failed to handle key, giving up", "key", key, "failures", failures, "error", err)
+			c.dropKey(queue, key, failures, err)
+			return true
+		}
+		c.logger.Error("Failed to handle key, requeueing", "key", key, "failures", failures, "error", err)
+		queue.AddRateLimited(key)
+		return true
+	}
+	c.clearFailures(queue, key)
+	queue.Forget(key)
+	return true
+}
+
+// Helper function to build a queue rate limiter: per-key exponential backoff from
+// backoff.Duration up to backoff.Cap, with the same overall rate limit as client-go's default
+func newRequeueRateLimiter(backoff wait.Backoff) workqueue.TypedRateLimiter[string] {
+	return workqueue.NewTypedMaxOfRateLimiter(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[string](backoff.Duration, backoff.Cap),
+		&workqueue.TypedBucketRateLimiter[string]{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// Helper function to count another consecutive failure of a key, returning the count
+func (c *Controller) recordFailure(queue workqueue.TypedRateLimitingInterface[string], key string) int {
+	c.failuresMu.Lock()
+	defer c.failuresMu.Unlock()
+	c.failures[failureKey{queue, key}]++
+	return c.failures[failureKey{queue, key}]
+}
+
+// Helper function to reset a key's consecutive failures
+func (c *Controller) clearFailures(queue workqueue.TypedRateLimitingInterface[string], key string) {
+	c.failuresMu.Lock()
+	defer c.failuresMu.Unlock()
+	delete(c.failures, failureKey{queue, key})
+}
+
+// Helper function to stop retrying a key that keeps failing, reporting the failure on the object
+// and in reboots_failed_total. It is picked up again on its next update, or on a resync for
+// pods, RebootRequests and nodes still in the reboot flow (see nodeInRebootFlow). An idle node
+// is not resynced, it waits for its next update.
+func (c *Controller) dropKey(queue workqueue.TypedRateLimitingInterface[string], key string, failures int, err error) {
+	c.clearFailures(queue, key)
+	queue.Forget(key)
+	rebootsFailedTotal.WithLabelValues(phaseRetriesExhausted).Inc()
+	if queue == c.nodeQueue {
+		c.updatePendingReboot(key, false, time.Now()) // No longer waiting on the controller
+		c.recordNodeFailure(key, fmt.Sprintf("giving up after %d failed attempts: %v", failures, err))
+	}
+
+	var obj runtime.Object
+	var lookupErr error
+	switch queue {
+	case c.nodeQueue:
+		obj, lookupErr = c.nodeLister.Get(key)
+	case c.podQueue:
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		obj, lookupErr = c.podLister.Pods(namespace).Get(name)
+	case c.rebootRequestQueue:
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		obj, lookupErr = c.rebootRequestLister.Namespace(namespace).Get(name)
+	}
+	if obj == nil || lookupErr != nil {
+		return // Gone, nothing to report the failure on
+	}
+	c.recorder.Eventf(obj, v1.EventTypeWarning, eventRebootFailed, "Giving up after %d failed attempts: %v", failures, err)
+}
+
+// Helper function to enqueue an object's key
+func (c *Controller) enqueue(queue workqueue.TypedRateLimitingInterface[string], obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		c.logger.Error("Failed to get object key", "error", err)
+		return
+	}
+	queue.Add(key)
+}
+
+// Looks up the node for a key and runs the node reboot logic on it
+func (c *Controller) syncNode(ctx context.Context, key string) error {
+	node, err := c.nodeLister.Get(key)
+	if apierrors.IsNotFound(err) {
+		c.updatePendingReboot(key, false, time.Now())
+		return nil // Deleted since it was queued
+	}
+	if err != nil {
+		return err
+	}
+	c.updatePendingReboot(node.Name, rebootPending(node, c.annotationKeys()), time.Now())
+	err = c.handleNodeAnnotations(ctx, node)
+	var rebootErr *RebootError
+	if errors.As(err, &rebootErr) {
+		rebootsFailedTotal.WithLabelValues(rebootErr.Phase).Inc()
+	}
+	return err
+}
+
+// Helper function to track since when a node has been waiting for its reboot to start, and
+// refresh the oldest pending reboot gauge
+func (c *Controller) updatePendingReboot(nodeName string, pending bool, now time.Time) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	if !pending {
+		delete(c.pendingSince, nodeName)
+	} else if _, tracked := c.pendingSince[nodeName]; !tracked {
+		c.pendingSince[nodeName] = now
+	}
+	oldest := 0.0
+	for _, since := range c.pendingSince {
+		oldest = max(oldest, now.Sub(since).Seconds())
+	}
+	oldestPendingRebootSeconds.Set(oldest)
+}
+
+// Helper function to check whether a node asks for a reboot that hasn't started: it carries a
+// valid reboot annotation or reboot-needed, isn't opted out and isn't rebooting already
+func rebootPending(node *v1.Node, keys AnnotationKeys) bool {
+	if _, noReboot := node.Annotations[keys.NoReboot]; noReboot || rebootInProgress(node, keys) {
+		return false
+	}
+	if _, needed := node.Annotations[keys.RebootNeeded]; needed {
+		return true
+	}
+	value, exists := node.Annotations[keys.Reboot]
+	if !exists {
+		return false
+	}
+	payload, err := parseRebootAnnotation(value)
+	return err == nil && payload != nil
+}
+
+// Helper function to check whether a pod, or the pod in a deletion tombstone, carries any of
+// the annotations handlePodAnnotations acts on
+func podHasRebootAnnotation(obj interface{}, keys AnnotationKeys) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	for _, key := range []string{keys.Reboot, keys.RebootNeeded, keys.RebootInProgress} {
+		if _, exists := pod.Annotations[key]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function for the node queue to look up a node's reboot priority from its cached
+// annotations, and whether it requests a reboot at all
+func (c *Controller) nodeRebootPriority(nodeName string) (int, bool) {
+	node, err := c.nodeLister.Get(nodeName)
+	if err != nil {
+		return 0, false
+	}
+	value, exists := node.Annotations[c.annotationKeys().Reboot]
+	if !exists {
+		return 0, false
+	}
+	payload, err := parseRebootAnnotation(value)
+	if err != nil || payload == nil {
+		return 0, false // Logged when the node is handled
+	}
+	return payload.Priority, true
+}
+
+// Looks up the pod for a key and runs the pod annotation handling on it
+func (c *Controller) syncPod(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil // Malformed keys can never succeed, don't requeue
+	}
+	pod, err := c.podLister.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil // Deleted since it was queued
+	}
+	if err != nil {
+		return err
+	}
+	return c.handlePodAnnotations(ctx, pod)
+}
+
+// RunOnce runs every cached node and pod through the same sync functions as the workers, one
+// at a time, and returns the errors of those that failed. Items that would be requeued to wait
+// (e.g. for a reboot slot) are logged and don't count as failures.
+func (c *Controller) RunOnce(ctx context.Context) error {
+	c.seedRebootLimiter()
+	var errs []error
+	run := func(sync func(context.Context, string) error, key string) {
+		err := sync(ctx, key)
+		var requeue *requeueError
+		if errors.As(err, &requeue) {
+			c.logger.Info("Skipping key that would wait", "key", key, "reason", requeue.reason)
+			return
+		}
+		if err != nil {
+			c.logger.Error("Failed to handle key", "key", key, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	// Same order as the node queue: highest reboot priority first
+	sort.SliceStable(nodes, func(i, j int) bool {
+		pi, _ := c.nodeRebootPriority(nodes[i].Name)
+		pj, _ := c.nodeRebootPriority(nodes[j].Name)
+		if pi != pj {
+			return pi > pj
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	for _, node := range nodes {
+		run(c.syncNode, node.Name)
+	}
+
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+	for _, pod := range pods {
+		run(c.syncPod, pod.Namespace+"/"+pod.Name)
+	}
+	return errors.Join(errs...)
+}
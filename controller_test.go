@@ -0,0 +1,627 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+// Helper function to build a controller over a fake clientset seeded with objects, with its
+// informers started and synced
+func newTestController(t *testing.T, objects ...runtime.Object) (*Controller, *fake.Clientset) {
+	t.Helper()
+	return newTestControllerWithConfig(t, testControllerConfig(t), objects...)
+}
+
+// Helper function to build a controller like newTestController, running with the given
+// settings. Settings read by the event handlers must be given here rather than changed on the
+// controller, as the informers are already running once it is returned.
+func newTestControllerWithConfig(t *testing.T, config controllerConfig, objects ...runtime.Object) (*Controller, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	return newTestControllerFor(t, client, factory, factory.Core().V1().Nodes().Informer(), factory.Core().V1().Pods().Informer(), config), client
+}
+
+// Helper function to build a controller with the given settings over the given informers from
+// factory, then start and sync them
+func newTestControllerFor(t *testing.T, client *fake.Clientset, factory informers.SharedInformerFactory, nodeInformer, podInformer cache.SharedIndexInformer, config controllerConfig) *Controller {
+	t.Helper()
+	c, err := NewController(discardLogger(), client, dynamicfake.NewSimpleDynamicClient(scheme.Scheme), record.NewFakeRecorder(100), nodeInformer, podInformer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, nodeInformer.HasSynced, podInformer.HasSynced) {
+		t.Fatal("informer caches did not sync")
+	}
+	return c
+}
+
+// Helper function to build the settings test controllers run with
+func testControllerConfig(t *testing.T) controllerConfig {
+	t.Helper()
+	return controllerConfig{
+		keys:            testKeys(t),
+		rebootLimiter:   newRebootLimiter(1),
+		decisions:       newDecisionLog(),
+		drainTimeout:    time.Minute,
+		stuckTimeout:    30 * time.Minute,
+		rebooter:        noopRebooter{logger: discardLogger()},
+		restartCooldown: newRestartCooldown(0),
+		ownerMaxDepth:   5,
+		apiTimeout:      time.Second,
+		conflictBackoff: retry.DefaultBackoff,
+		requeueBackoff:  wait.Backoff{Duration: time.Millisecond, Cap: time.Second},
+	}
+}
+
+// Helper function to build a controller that only restarts workloads, with no informers behind it
+func newTestRestarter(t *testing.T, client kubernetes.Interface, dynamicClient dynamic.Interface, recorder record.EventRecorder, cooldown *restartCooldown) *Controller {
+	t.Helper()
+	config := testControllerConfig(t)
+	config.restartCooldown = cooldown
+	return &Controller{controllerConfig: config, logger: discardLogger(), clientset: client, dynamicClient: dynamicClient, recorder: recorder}
+}
+
+func TestUpdatePendingReboot(t *testing.T) {
+	keys := testKeys(t)
+	c, _ := newTestController(t)
+	start := time.Now()
+
+	c.updatePendingReboot("node-1", true, start)
+	c.updatePendingReboot("node-2", true, start.Add(time.Minute))
+	c.updatePendingReboot("node-1", true, start.Add(2*time.Minute)) // Still waiting, keeps its start
+
+	if got := testutil.ToFloat64(oldestPendingRebootSeconds); got != 120 {
+		t.Errorf("reboot_agent_oldest_pending_reboot_seconds = %v, want 120", got)
+	}
+
+	c.updatePendingReboot("node-1", false, start.Add(3*time.Minute))
+	if got := testutil.ToFloat64(oldestPendingRebootSeconds); got != 120 {
+		t.Errorf("reboot_agent_oldest_pending_reboot_seconds after node-1 started = %v, want 120 for node-2", got)
+	}
+	c.updatePendingReboot("node-2", false, start.Add(3*time.Minute))
+	if got := testutil.ToFloat64(oldestPendingRebootSeconds); got != 0 {
+		t.Errorf("reboot_agent_oldest_pending_reboot_seconds with nothing pending = %v, want 0", got)
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{"nothing", nil, false},
+		{"reboot", map[string]string{keys.Reboot: ""}, true},
+		{"reboot false", map[string]string{keys.Reboot: "false"}, false},
+		{"reboot-needed", map[string]string{keys.RebootNeeded: ""}, true},
+		{"in progress", map[string]string{keys.Reboot: "", keys.RebootInProgress: "2024-01-01T00:00:00Z"}, false},
+		{"opted out", map[string]string{keys.Reboot: "", keys.NoReboot: ""}, false},
+	}
+	for _, tt := range tests {
+		if got := rebootPending(testNode("node", tt.annotations), keys); got != tt.want {
+			t.Errorf("rebootPending(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNodeUpdatedSkipsStatusOnlyChanges(t *testing.T) {
+	keys := testKeys(t)
+	heartbeat := func(node *v1.Node, at time.Time) *v1.Node {
+		node = node.DeepCopy()
+		node.ResourceVersion = strconv.FormatInt(at.UnixNano(), 10)
+		node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue, LastHeartbeatTime: metav1.NewTime(at)}}
+		return node
+	}
+	base := testNode("node-1", map[string]string{keys.Reboot: ""})
+	base.Status.NodeInfo.BootID = "boot-1"
+	now := time.Now()
+
+	rebooted := heartbeat(base, now)
+	rebooted.Status.NodeInfo.BootID = "boot-2"
+	annotated := heartbeat(base, now)
+	annotated.Annotations = map[string]string{keys.Reboot: "", keys.RebootNeeded: ""}
+
+	tests := []struct {
+		name     string
+		newNode  *v1.Node
+		enqueued bool
+	}{
+		{"heartbeat", heartbeat(base, now), false},
+		{"boot ID changed", rebooted, true},
+		{"annotation changed", annotated, true},
+	}
+	for _, tt := range tests {
+		c, _ := newTestController(t)
+		c.nodeUpdated(heartbeat(base, now.Add(-time.Minute)), tt.newNode)
+		if got := c.nodeQueue.Len() == 1; got != tt.enqueued {
+			t.Errorf("%s: enqueued = %v, want %v", tt.name, got, tt.enqueued)
+		}
+	}
+}
+
+func TestPodUpdatedSkipsUnrelatedChanges(t *testing.T) {
+	keys := testKeys(t)
+	base := testPod("default", "web-1", "node-1", "ReplicaSet")
+	base.Annotations = map[string]string{keys.Reboot: ""}
+	base.ResourceVersion = "1"
+	update := func(annotations map[string]string) *v1.Pod {
+		pod := base.DeepCopy()
+		pod.ResourceVersion = "2"
+		pod.Annotations = annotations
+		return pod
+	}
+	statusOnly := update(base.Annotations)
+	statusOnly.Status.Phase = v1.PodRunning
+
+	tests := []struct {
+		name     string
+		newPod   *v1.Pod
+		enqueued bool
+	}{
+		{"status change", statusOnly, false},
+		{"unrelated annotation", update(map[string]string{keys.Reboot: "", "team": "payments"}), false},
+		{"reboot annotation changed", update(map[string]string{keys.Reboot: "true"}), true},
+		{"resync", base.DeepCopy(), true},
+	}
+	for _, tt := range tests {
+		c, _ := newTestController(t)
+		c.podUpdated(base, tt.newPod)
+		if got := c.podQueue.Len() == 1; got != tt.enqueued {
+			t.Errorf("%s: enqueued = %v, want %v", tt.name, got, tt.enqueued)
+		}
+	}
+}
+
+func TestPodHasRebootAnnotation(t *testing.T) {
+	keys := testKeys(t)
+	pod := func(annotations map[string]string) *v1.Pod {
+		pod := testPod("default", "web-1", "node-1", "ReplicaSet")
+		pod.Annotations = annotations
+		return pod
+	}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want bool
+	}{
+		{"no annotations", pod(nil), false},
+		{"unrelated annotation", pod(map[string]string{"team": "payments"}), false},
+		{"reboot", pod(map[string]string{keys.Reboot: ""}), true},
+		{"reboot needed", pod(map[string]string{keys.RebootNeeded: ""}), true},
+		{"reboot in progress", pod(map[string]string{keys.RebootInProgress: ""}), true},
+		{"tombstone of an annotated pod", cache.DeletedFinalStateUnknown{Key: "default/web-1", Obj: pod(map[string]string{keys.Reboot: ""})}, true},
+		{"tombstone of a plain pod", cache.DeletedFinalStateUnknown{Key: "default/web-1", Obj: pod(nil)}, false},
+		{"not a pod", testNode("node-1", map[string]string{keys.Reboot: ""}), false},
+	}
+	for _, tt := range tests {
+		if got := podHasRebootAnnotation(tt.obj, keys); got != tt.want {
+			t.Errorf("%s: podHasRebootAnnotation() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Only annotated pods reach the queue
+	annotated := testPod("default", "web-1", "node-1", "ReplicaSet")
+	annotated.Annotations = map[string]string{keys.Reboot: ""}
+	c, _ := newTestController(t, annotated, testPod("default", "web-2", "node-1", "ReplicaSet"))
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		return c.podQueue.Len() > 0, nil
+	})
+	if err != nil {
+		t.Fatal("annotated pod never queued")
+	}
+	if key, _ := c.podQueue.Get(); key != "default/web-1" || c.podQueue.Len() != 0 {
+		t.Errorf("queued %s and %d more, want only default/web-1", key, c.podQueue.Len())
+	}
+}
+
+func TestResyncReevaluates(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		enqueued    bool
+	}{
+		{"idle", nil, false},
+		{"unrelated annotation", map[string]string{"team": "payments"}, false},
+		{"reboot requested", map[string]string{keys.Reboot: ""}, true},
+		{"reboot needed", map[string]string{keys.RebootNeeded: ""}, true},
+		{"rebooting", map[string]string{keys.RebootInProgress: "2024-01-01T00:00:00Z"}, true},
+		{"left cordoned", map[string]string{keys.CordonedByAgent: ""}, true},
+	}
+	for _, tt := range tests {
+		c, _ := newTestController(t)
+		node := testNode("node-1", tt.annotations)
+		node.ResourceVersion = "7"
+		c.nodeUpdated(node, node.DeepCopy())
+		if got := c.nodeQueue.Len() == 1; got != tt.enqueued {
+			t.Errorf("%s: enqueued on resync = %v, want %v", tt.name, got, tt.enqueued)
+		}
+	}
+
+	// A node still carrying the reboot taint is retried too
+	c, _ := newTestController(t)
+	c.rebootTaint = &v1.Taint{Key: "reboot-agent/rebooting", Value: "true", Effect: v1.TaintEffectNoExecute}
+	node := testNode("node-1", nil)
+	node.Spec.Taints = []v1.Taint{*c.rebootTaint}
+	c.nodeUpdated(node, node.DeepCopy())
+	if c.nodeQueue.Len() != 1 {
+		t.Error("tainted node not enqueued on resync")
+	}
+
+	// Pods only reach the handler with a reboot annotation, so every resync re-evaluates them
+	pod := testPod("default", "web-1", "node-1", "ReplicaSet")
+	pod.Annotations = map[string]string{keys.Reboot: ""}
+	pod.ResourceVersion = "7"
+	c.podUpdated(pod, pod.DeepCopy())
+	if c.podQueue.Len() != 1 {
+		t.Error("annotated pod not enqueued on resync")
+	}
+}
+
+// blockingRebooter holds every reboot until release is closed, reporting each one on started
+type blockingRebooter struct {
+	started chan string
+	release chan struct{}
+}
+
+func (r *blockingRebooter) Reboot(ctx context.Context, node *v1.Node) error {
+	r.started <- node.Name
+	<-r.release
+	return nil
+}
+
+func (r *blockingRebooter) Verify(ctx context.Context, node *v1.Node) error {
+	return nil
+}
+
+func TestShutdownWaitsForInFlightItems(t *testing.T) {
+	keys := testKeys(t)
+	c, client := newTestController(t, testNode("node-1", map[string]string{keys.Reboot: ""}))
+	rebooter := &blockingRebooter{started: make(chan string, 1), release: make(chan struct{})}
+	c.rebooter = rebooter
+
+	stopCh := make(chan struct{})
+	c.Start(context.Background(), 1, 1, stopCh)
+	select {
+	case <-rebooter.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reboot never started")
+	}
+
+	// The signal arrives while the reboot is in flight
+	close(stopCh)
+	if c.Wait(100 * time.Millisecond) {
+		t.Fatal("Wait() returned while the reboot was still in flight")
+	}
+	close(rebooter.release)
+	if !c.Wait(5 * time.Second) {
+		t.Fatal("Wait() timed out after the in-flight reboot finished")
+	}
+
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rebootInProgress(node, keys) {
+		t.Errorf("in-flight reboot did not complete, annotations %v", node.Annotations)
+	}
+}
+
+func TestShutdownProcessesQueuedItems(t *testing.T) {
+	keys := testKeys(t)
+	config := testControllerConfig(t)
+	config.rebootLimiter = newRebootLimiter(2)
+	c, client := newTestControllerWithConfig(t, config,
+		testNode("node-1", map[string]string{keys.Reboot: ""}),
+		testNode("node-2", map[string]string{keys.Reboot: ""}),
+	)
+	rebooter := &blockingRebooter{started: make(chan string, 2), release: make(chan struct{})}
+	c.rebooter = rebooter
+
+	// One worker: the first node's reboot blocks it while the second node waits in the queue
+	stopCh := make(chan struct{})
+	c.Start(context.Background(), 1, 1, stopCh)
+	select {
+	case <-rebooter.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reboot never started")
+	}
+	if c.nodeQueue.Len() != 1 {
+		t.Fatalf("node queue holds %d keys, want the second node waiting", c.nodeQueue.Len())
+	}
+
+	// Only let the first reboot finish once the queue is shutting down
+	close(stopCh)
+	if err := wait.PollUntilContextTimeout(context.Background(), time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return c.nodeQueue.ShuttingDown(), nil
+	}); err != nil {
+		t.Fatal("node queue never started shutting down")
+	}
+	close(rebooter.release)
+	if !c.Wait(5 * time.Second) {
+		t.Fatal("Wait() timed out draining the queue")
+	}
+	for _, name := range []string{"node-1", "node-2"} {
+		node, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rebootInProgress(node, keys) {
+			t.Errorf("%s not rebooted before shutdown completed, annotations %v", name, node.Annotations)
+		}
+	}
+}
+
+func TestProcessNextItem(t *testing.T) {
+	c, _ := newTestController(t)
+	calls := map[string]int{}
+	sync := func(ctx context.Context, key string) error {
+		calls[key]++
+		if key == "failing" {
+			return errors.New("API unavailable")
+		}
+		return nil
+	}
+
+	c.podQueue.Add("default/web-1")
+	if !c.processNextItem(context.Background(), c.podQueue, sync) {
+		t.Fatal("processNextItem() returned false on a live queue")
+	}
+	if calls["default/web-1"] != 1 {
+		t.Errorf("handler invoked %d times, want once", calls["default/web-1"])
+	}
+	if c.podQueue.Len() != 0 || c.podQueue.NumRequeues("default/web-1") != 0 {
+		t.Errorf("handled key still queued (len %d) or rate limited (%d requeues)", c.podQueue.Len(), c.podQueue.NumRequeues("default/web-1"))
+	}
+
+	c.podQueue.Add("failing")
+	c.processNextItem(context.Background(), c.podQueue, sync)
+	if c.podQueue.NumRequeues("failing") != 1 {
+		t.Errorf("failed key requeued %d times, want once with rate limiting", c.podQueue.NumRequeues("failing"))
+	}
+}
+
+func TestProcessNextItemGivesUp(t *testing.T) {
+	c, _ := newTestController(t, testNode("node-1", nil))
+	c.requeueBackoff.Steps = 3
+	failed := testutil.ToFloat64(rebootsFailedTotal.WithLabelValues(phaseRetriesExhausted))
+	attempts := 0
+	sync := func(ctx context.Context, key string) error {
+		attempts++
+		return errors.New("API unavailable")
+	}
+
+	c.updatePendingReboot("node-1", true, time.Now().Add(-time.Minute))
+	c.nodeQueue.Add("node-1")
+	for i := 0; i < 3; i++ {
+		c.processNextItem(context.Background(), c.nodeQueue, sync)
+	}
+	if attempts != 3 {
+		t.Fatalf("handler invoked %d times, want 3", attempts)
+	}
+	if c.nodeQueue.Len() != 0 || c.nodeQueue.NumRequeues("node-1") != 0 {
+		t.Errorf("key still queued (len %d) or rate limited (%d requeues) after the last retry", c.nodeQueue.Len(), c.nodeQueue.NumRequeues("node-1"))
+	}
+	if got := testutil.ToFloat64(rebootsFailedTotal.WithLabelValues(phaseRetriesExhausted)) - failed; got != 1 {
+		t.Errorf("reboots_failed_total{phase=%q} rose by %v, want 1", phaseRetriesExhausted, got)
+	}
+	events := recordedEvents(c.recorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning "+eventRebootFailed+" Giving up after 3 failed attempts") {
+		t.Errorf("events = %q, want one Warning %s giving up after 3 attempts", events, eventRebootFailed)
+	}
+	if got := testutil.ToFloat64(oldestPendingRebootSeconds); got != 0 || len(c.pendingSince) != 0 {
+		t.Errorf("reboot_agent_oldest_pending_reboot_seconds = %v tracking %v, want the dropped node forgotten", got, c.pendingSince)
+	}
+}
+
+func TestQueueDepthGauge(t *testing.T) {
+	c, _ := newTestController(t)
+	sync := func(ctx context.Context, key string) error { return nil }
+
+	for _, key := range []string{"node-1", "node-2", "node-3"} {
+		c.nodeQueue.Add(key)
+	}
+	c.processNextItem(context.Background(), c.nodeQueue, sync)
+	if got := testutil.ToFloat64(queueDepth); got != 2 {
+		t.Errorf("reboot_agent_queue_depth = %v, want 2", got)
+	}
+	c.processNextItem(context.Background(), c.nodeQueue, sync)
+	c.processNextItem(context.Background(), c.nodeQueue, sync)
+	if got := testutil.ToFloat64(queueDepth); got != 0 {
+		t.Errorf("reboot_agent_queue_depth with the queue drained = %v, want 0", got)
+	}
+}
+
+// Helper function to wait for the controller's node cache to catch up with a change
+func waitForCachedNode(t *testing.T, c *Controller, name string, done func(*v1.Node) bool) {
+	t.Helper()
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		node, err := c.nodeLister.Get(name)
+		return err == nil && done(node), nil
+	})
+	if err != nil {
+		t.Fatalf("node %s cache never caught up: %v", name, err)
+	}
+}
+
+// Helper function to list which nodes are marked reboot-in-progress
+func nodesInProgress(t *testing.T, client *fake.Clientset, keys AnnotationKeys) []string {
+	t.Helper()
+	nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, node := range nodes.Items {
+		if rebootInProgress(&node, keys) {
+			names = append(names, node.Name)
+		}
+	}
+	return names
+}
+
+func TestRebootLimitOneAtATime(t *testing.T) {
+	keys := testKeys(t)
+	var nodes []runtime.Object
+	for _, name := range []string{"node-1", "node-2", "node-3"} {
+		node := testNode(name, map[string]string{keys.Reboot: ""})
+		node.Status.NodeInfo.BootID = "boot-1"
+		nodes = append(nodes, node)
+	}
+	c, client := newTestController(t, nodes...)
+	syncAll := func() {
+		for _, name := range []string{"node-1", "node-2", "node-3"} {
+			var requeue *requeueError
+			if err := c.syncNode(context.Background(), name); err != nil && !errors.As(err, &requeue) {
+				t.Fatalf("syncNode(%s) failed: %v", name, err)
+			}
+		}
+	}
+
+	for _, want := range []string{"node-1", "node-2", "node-3"} {
+		syncAll()
+		inProgress := nodesInProgress(t, client, keys)
+		if len(inProgress) != 1 || inProgress[0] != want {
+			t.Fatalf("in progress = %v, want only %s", inProgress, want)
+		}
+
+		// The node comes back with a new boot ID, completing its reboot and freeing the slot
+		node, err := client.CoreV1().Nodes().Get(context.Background(), want, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.Status.NodeInfo.BootID = "boot-2"
+		if _, err := client.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitForCachedNode(t, c, want, func(node *v1.Node) bool { return node.Status.NodeInfo.BootID == "boot-2" })
+		if err := c.syncNode(context.Background(), want); err != nil {
+			t.Fatalf("syncNode(%s) completing the reboot failed: %v", want, err)
+		}
+		waitForCachedNode(t, c, want, func(node *v1.Node) bool { return !rebootInProgress(node, keys) })
+	}
+}
+
+func TestRebootLimitHeldAcrossRestarts(t *testing.T) {
+	keys := testKeys(t)
+	// A previous leader started node-2's reboot; node-1 sorts first but must wait for it
+	c, client := newTestController(t,
+		testNode("node-1", map[string]string{keys.Reboot: ""}),
+		testNode("node-2", map[string]string{keys.RebootInProgress: time.Now().UTC().Format(time.RFC3339)}),
+	)
+	if err := c.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() failed: %v", err)
+	}
+	if inProgress := nodesInProgress(t, client, keys); len(inProgress) != 1 || inProgress[0] != "node-2" {
+		t.Errorf("in progress = %v, want only node-2", inProgress)
+	}
+
+	// Deleting the rebooting node frees its slot
+	if err := client.CoreV1().Nodes().Delete(context.Background(), "node-2", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		return c.rebootLimiter.tryAcquire("node-1", ""), nil
+	})
+	if err != nil {
+		t.Errorf("slot of the deleted node never freed: %v", err)
+	}
+}
+
+func TestRebootLimitPerMaintenanceGroup(t *testing.T) {
+	keys := testKeys(t)
+	groups := map[string]string{"node-a1": "a", "node-a2": "a", "node-b1": "b", "node-c1": "", "node-c2": ""}
+	var nodes []runtime.Object
+	for name, group := range groups {
+		node := testNode(name, map[string]string{keys.Reboot: ""})
+		if group != "" {
+			node.Labels = map[string]string{"example.com/maintenance-group": group}
+		}
+		nodes = append(nodes, node)
+	}
+	c, client := newTestController(t, nodes...)
+	c.rebootLimiter.groupLabel = "example.com/maintenance-group"
+
+	for _, name := range []string{"node-a1", "node-a2", "node-b1", "node-c1", "node-c2"} {
+		var requeue *requeueError
+		if err := c.syncNode(context.Background(), name); err != nil && !errors.As(err, &requeue) {
+			t.Fatalf("syncNode(%s) failed: %v", name, err)
+		}
+	}
+	// One reboot per group, nodes without the label sharing the default group
+	inProgress := nodesInProgress(t, client, keys)
+	slices.Sort(inProgress)
+	if want := []string{"node-a1", "node-b1", "node-c1"}; !slices.Equal(inProgress, want) {
+		t.Errorf("in progress = %v, want %v", inProgress, want)
+	}
+}
+
+func TestNodeLabelSelector(t *testing.T) {
+	keys := testKeys(t)
+	worker := testNode("worker-1", map[string]string{keys.Reboot: ""})
+	worker.Labels = map[string]string{"node-role": "worker"}
+	other := testNode("control-plane-1", map[string]string{keys.Reboot: ""})
+	other.Labels = map[string]string{"node-role": "control-plane"}
+	client := fake.NewSimpleClientset(worker, other)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	selector, err := labels.Parse("node-role=worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestControllerFor(t, client, factory, newNodeInformer(factory, selector, ""), newPodInformer(factory, "", ""), testControllerConfig(t))
+	c.rebootLimiter = newRebootLimiter(2)
+
+	if err := c.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() failed: %v", err)
+	}
+	if _, handled := c.decisions.get("control-plane-1"); handled {
+		t.Error("node outside the selector was handled")
+	}
+	if inProgress := nodesInProgress(t, client, keys); len(inProgress) != 1 || inProgress[0] != "worker-1" {
+		t.Errorf("in progress = %v, want only worker-1", inProgress)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	keys := testKeys(t)
+	c, client := newTestController(t, testNode("node-1", map[string]string{keys.Reboot: ""}), testNode("node-2", nil))
+	if err := c.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() failed: %v", err)
+	}
+	if inProgress := nodesInProgress(t, client, keys); len(inProgress) != 1 || inProgress[0] != "node-1" {
+		t.Errorf("in progress = %v, want only node-1", inProgress)
+	}
+
+	// A reboot that fails makes the whole run fail
+	c, _ = newTestController(t, testNode("node-1", map[string]string{keys.Reboot: ""}))
+	c.rebooter = failingRebooter{err: errors.New("connection refused")}
+	err := c.RunOnce(context.Background())
+	var rebootErr *RebootError
+	if !errors.As(err, &rebootErr) || rebootErr.Node != "node-1" {
+		t.Errorf("RunOnce() error = %v, want node-1's reboot error", err)
+	}
+}
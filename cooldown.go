@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Identifies a restarted workload
+type restartKey struct {
+	kind string
+	types.NamespacedName
+}
+
+// restartCooldown remembers when this agent last restarted each workload, so a pod that keeps
+// regaining the reboot annotation doesn't roll its workload over and over. It complements the
+// restartedAt check on pod templates, and covers rollout CRs which don't carry that annotation.
+// It also serializes restarts of the same workload across pod workers, and remembers restarts
+// for at least restartMemory even without a cooldown, so pods of one workload handled at once
+// don't each restart it.
+type restartCooldown struct {
+	period time.Duration
+
+	mu          sync.Mutex
+	lastRestart map[restartKey]time.Time
+	locks       map[restartKey]*workloadLock
+}
+
+// How long a restart is remembered at least, to tell which pods it already replaces
+const restartMemory = time.Hour
+
+// A per-workload lock, dropped from the map once nobody holds or waits for it
+type workloadLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newRestartCooldown(period time.Duration) *restartCooldown {
+	return &restartCooldown{
+		period:      period,
+		lastRestart: make(map[restartKey]time.Time),
+		locks:       make(map[restartKey]*workloadLock),
+	}
+}
+
+// Helper function to hold the workload's lock while checking the cooldown and restarting it.
+// Returns the function releasing it.
+func (c *restartCooldown) lock(key restartKey) func() {
+	c.mu.Lock()
+	l, ok := c.locks[key]
+	if !ok {
+		l = &workloadLock{}
+		c.locks[key] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.locks, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Helper function to check whether the workload was restarted within the cooldown period
+func (c *restartCooldown) active(key restartKey) bool {
+	if c.period <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, ok := c.lastRestart[key]
+	return ok && time.Since(last) < c.period
+}
+
+// Helper function to check whether the workload was restarted at or after the given time, e.g.
+// since a pod was created, making a restart for that pod redundant
+func (c *restartCooldown) restartedSince(key restartKey, since time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, ok := c.lastRestart[key]
+	return ok && !last.Before(since)
+}
+
+// Helper function to record that the workload was just restarted, forgetting restarts older
+// than both the cooldown and restartMemory to keep the map from growing
+func (c *restartCooldown) record(key restartKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for restarted, last := range c.lastRestart {
+		if now.Sub(last) >= max(c.period, restartMemory) {
+			delete(c.lastRestart, restarted)
+		}
+	}
+	c.lastRestart[key] = now
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func TestRestartCooldown(t *testing.T) {
+	client := fake.NewSimpleClientset(testDeployment("default", "web", time.Time{}))
+	cooldown := newRestartCooldown(5 * time.Minute)
+	for _, name := range []string{"web-1", "web-2"} {
+		pod := testPod("default", name, "node-1", "ReplicaSet")
+		owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+		if err := newTestRestarter(t, client, nil, record.NewFakeRecorder(10), cooldown).triggerRolloutRestart(context.Background(), discardLogger(), owner, pod); err != nil {
+			t.Fatalf("triggerRolloutRestart() for %s failed: %v", name, err)
+		}
+	}
+
+	updates := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Errorf("deployment updated %d times, want only the first restart", updates)
+	}
+}
+
+func TestRestartedSince(t *testing.T) {
+	cooldown := newRestartCooldown(0)
+	key := restartKey{kind: "Deployment", NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	before := time.Now().Add(-time.Minute)
+	if cooldown.restartedSince(key, before) {
+		t.Error("never restarted workload reported as restarted")
+	}
+	cooldown.record(key)
+	if !cooldown.restartedSince(key, before) {
+		t.Error("restart not remembered without a cooldown")
+	}
+	if cooldown.restartedSince(key, time.Now().Add(time.Minute)) {
+		t.Error("restart reported for a pod created after it")
+	}
+	if cooldown.active(key) {
+		t.Error("cooldown active with the cooldown disabled")
+	}
+}
+
+func TestRestartedWithin(t *testing.T) {
+	at := func(ago time.Duration) map[string]string {
+		return map[string]string{restartedAtAnnotation: time.Now().Add(-ago).Format(time.RFC3339)}
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		cooldown    time.Duration
+		want        bool
+	}{
+		{"never restarted", nil, 5 * time.Minute, false},
+		{"invalid timestamp", map[string]string{restartedAtAnnotation: "yesterday"}, 5 * time.Minute, false},
+		{"within the cooldown", at(time.Minute), 5 * time.Minute, true},
+		{"after the cooldown", at(10 * time.Minute), 5 * time.Minute, false},
+		{"cooldown disabled", at(time.Second), 0, false},
+	}
+	for _, tt := range tests {
+		if got := restartedWithin(tt.annotations, tt.cooldown); got != tt.want {
+			t.Errorf("%s: restartedWithin() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrentRestartsOncePerWorkload(t *testing.T) {
+	keys := testKeys(t)
+	var objects, replicaSets []runtime.Object
+	for _, name := range []string{"web", "api", "worker"} {
+		objects = append(objects, testDeployment("default", name, time.Time{}))
+		replicaSets = append(replicaSets, &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default", Name: name + "-rs",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: name, Controller: ptr.To(true)}},
+		}})
+	}
+	for i := 0; i < 10; i++ {
+		deployment := []string{"web", "api", "worker"}[i%3]
+		pod := testPod("default", fmt.Sprintf("%s-%d", deployment, i), "node-1", "")
+		pod.Annotations = map[string]string{keys.Reboot: ""}
+		pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: deployment + "-rs", Controller: ptr.To(true)}}
+		objects = append(objects, pod)
+	}
+	c, client := newTestController(t, objects...)
+	c.dynamicClient = dynamicfake.NewSimpleDynamicClient(scheme.Scheme, replicaSets...)
+	// No cooldown: the pods still restart each workload once, as the first restart already
+	// replaces the pods handled at the same time
+	c.restartCooldown = newRestartCooldown(0)
+
+	// All ten pods are enqueued by the informer; sync them at once, as --restart-concurrency
+	// pod workers would
+	deadline := time.Now().Add(5 * time.Second)
+	for c.podQueue.Len() < 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d pods queued, want 10", c.podQueue.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for c.podQueue.Len() > 0 {
+		key, _ := c.podQueue.Get()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.podQueue.Done(key)
+			errs <- c.syncPod(context.Background(), key)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("syncPod() failed: %v", err)
+		}
+	}
+
+	updated := map[string]int{}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetResource().Resource == "deployments" {
+			updated[action.(k8stesting.UpdateAction).GetObject().(*appsv1.Deployment).Name]++
+		}
+	}
+	if len(updated) != 3 || updated["web"] != 1 || updated["api"] != 1 || updated["worker"] != 1 {
+		t.Errorf("deployment updates = %v, want exactly one for each of web, api and worker", updated)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// cordonNode marks the node unschedulable ahead of a reboot. A node that is already cordoned
+// is left alone so an operator's cordon outlives the reboot; otherwise the agent records that
+// it did the cordoning so uncordonNode knows to undo it.
+func cordonNode(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, node *v1.Node, keys AnnotationKeys, apiTimeout time.Duration, dryRun bool) error {
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	return patchNodeSchedulable(ctx, logger, client, node.Name, keys, true, ptr.To(""), apiTimeout, dryRun)
+}
+
+// uncordonNode makes the node schedulable again, but only if the agent cordoned it
+func uncordonNode(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, node *v1.Node, keys AnnotationKeys, apiTimeout time.Duration, dryRun bool) error {
+	if !cordonedByAgent(node, keys) {
+		return nil
+	}
+	return patchNodeSchedulable(ctx, logger, client, node.Name, keys, false, nil, apiTimeout, dryRun)
+}
+
+// Helper function to check whether the agent cordoned the node
+func cordonedByAgent(node *v1.Node, keys AnnotationKeys) bool {
+	_, cordoned := node.Annotations[keys.CordonedByAgent]
+	return cordoned
+}
+
+// Helper function to set spec.unschedulable and the cordoned-by-agent marker in one patch, so
+// the marker can never disagree with the cordon. A nil marker removes it.
+func patchNodeSchedulable(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, nodeName string, keys AnnotationKeys, unschedulable bool, marker *string, apiTimeout time.Duration, dryRun bool) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"unschedulable": unschedulable,
+		},
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{keys.CordonedByAgent: marker},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build cordon patch: %w", err)
+	}
+	if dryRun {
+		logger.Info("Dry run: would patch node", "patch", string(patch))
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+	_, err = client.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Helper function to run a node through a whole reboot: start it, bring the node back with a
+// new boot ID and complete it, returning whether the node was cordoned while rebooting
+func rebootCycle(t *testing.T, c *Controller, client *fake.Clientset, name string) bool {
+	t.Helper()
+	if err := c.syncNode(context.Background(), name); err != nil {
+		t.Fatalf("syncNode() starting the reboot failed: %v", err)
+	}
+	node, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rebootInProgress(node, c.keys) {
+		t.Fatalf("reboot not started, annotations %v", node.Annotations)
+	}
+	cordoned := node.Spec.Unschedulable
+
+	node.Status.NodeInfo.BootID = "boot-2"
+	if _, err := client.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForCachedNode(t, c, name, func(node *v1.Node) bool { return node.Status.NodeInfo.BootID == "boot-2" })
+	if err := c.syncNode(context.Background(), name); err != nil {
+		t.Fatalf("syncNode() completing the reboot failed: %v", err)
+	}
+	return cordoned
+}
+
+func TestCordonDuringReboot(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	node.Status.NodeInfo.BootID = "boot-1"
+	c, client := newTestController(t, node)
+
+	if !rebootCycle(t, c, client, "node-1") {
+		t.Error("node not cordoned while rebooting")
+	}
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Unschedulable || cordonedByAgent(got, keys) {
+		t.Errorf("node still cordoned after the reboot: unschedulable=%v annotations %v", got.Spec.Unschedulable, got.Annotations)
+	}
+}
+
+func TestPreCordonedNodeStaysCordoned(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	node.Status.NodeInfo.BootID = "boot-1"
+	node.Spec.Unschedulable = true // Cordoned by an operator
+	c, client := newTestController(t, node)
+
+	rebootCycle(t, c, client, "node-1")
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Spec.Unschedulable {
+		t.Error("operator's cordon was lifted by the reboot")
+	}
+	if cordonedByAgent(got, keys) {
+		t.Errorf("agent claimed the operator's cordon, annotations %v", got.Annotations)
+	}
+}
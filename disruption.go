@@ -0,0 +1,70 @@
+package main
+
+import "sync"
+
+// disruptionBudget bounds node reboots and workload restarts together, so the two paths can't
+// jointly disrupt more of the cluster than --max-total-disruptions allows. A node holds a slot
+// from the moment it is picked for a reboot until the reboot completes, like its rebootLimiter
+// slot; a workload restart holds one while it runs, including any wait for its rollout. A nil
+// disruptionBudget doesn't limit or count.
+type disruptionBudget struct {
+	mu      sync.Mutex
+	max     int // 0 counts disruptions without limiting them
+	holders map[string]struct{}
+}
+
+func newDisruptionBudget(max int) *disruptionBudget {
+	return &disruptionBudget{max: max, holders: make(map[string]struct{})}
+}
+
+// Helper functions to name the slot held by a rebooting node or a restarting workload
+func nodeDisruption(nodeName string) string {
+	return "node/" + nodeName
+}
+
+func restartDisruption(key restartKey) string {
+	return "restart/" + key.kind + "/" + key.String()
+}
+
+// tryAcquire takes a slot for the key if one is free. A key that already holds a slot acquires
+// it again without taking another.
+func (b *disruptionBudget) tryAcquire(key string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, held := b.holders[key]; held {
+		return true
+	}
+	if b.max > 0 && len(b.holders) >= b.max {
+		return false
+	}
+	b.holders[key] = struct{}{}
+	disruptionsInProgress.Set(float64(len(b.holders)))
+	return true
+}
+
+// hold records that the key holds a slot, even past the limit, e.g. a node found rebooting
+// after a restart
+func (b *disruptionBudget) hold(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holders[key] = struct{}{}
+	disruptionsInProgress.Set(float64(len(b.holders)))
+}
+
+// release frees the key's slot, if it holds one
+func (b *disruptionBudget) release(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.holders, key)
+	disruptionsInProgress.Set(float64(len(b.holders)))
+}
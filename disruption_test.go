@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDisruptionBudgetSharedByReboots(t *testing.T) {
+	budget := newDisruptionBudget(2)
+	limiter := newRebootLimiter(3)
+	limiter.budget = budget
+
+	if !budget.tryAcquire("restart/Deployment/default/web") || !limiter.tryAcquire("node-1", "") {
+		t.Fatal("restart and reboot refused with the budget free")
+	}
+	if limiter.tryAcquire("node-2", "") {
+		t.Error("node-2 got a reboot slot with the disruption budget spent")
+	}
+	if got := testutil.ToFloat64(disruptionsInProgress); got != 2 {
+		t.Errorf("reboot_agent_disruptions_in_progress = %v, want 2", got)
+	}
+
+	limiter.release("node-1")
+	if !limiter.tryAcquire("node-2", "") {
+		t.Error("node-2 refused a slot once node-1's reboot completed")
+	}
+	// A node found rebooting keeps its slot whatever the budget
+	limiter.hold("node-3", "")
+	if got := testutil.ToFloat64(disruptionsInProgress); got != 3 {
+		t.Errorf("reboot_agent_disruptions_in_progress = %v, want 3 with node-3 held past the limit", got)
+	}
+}
+
+func TestDisruptionBudgetDefersRestarts(t *testing.T) {
+	client := fake.NewSimpleClientset(testDeployment("default", "web", time.Time{}))
+	budget := newDisruptionBudget(1)
+	limiter := newRebootLimiter(1)
+	limiter.budget = budget
+	c := newTestRestarter(t, client, nil, record.NewFakeRecorder(10), newRestartCooldown(0))
+	c.disruptions = budget
+	owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+	pod := testPod("default", "web-1", "node-2", "ReplicaSet")
+
+	// A node rebooting takes the only slot, the restart waits for it
+	limiter.tryAcquire("node-1", "")
+	err := c.triggerRolloutRestart(context.Background(), discardLogger(), owner, pod)
+	var requeue *requeueError
+	if !errors.As(err, &requeue) {
+		t.Fatalf("triggerRolloutRestart() error = %v, want a requeue while the budget is spent", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("deployment touched while waiting for a slot: %v", client.Actions())
+	}
+
+	limiter.release("node-1")
+	if err := c.triggerRolloutRestart(context.Background(), discardLogger(), owner, pod); err != nil {
+		t.Fatalf("triggerRolloutRestart() failed once the slot was free: %v", err)
+	}
+	updated := false
+	for _, action := range client.Actions() {
+		updated = updated || action.GetVerb() == "update"
+	}
+	if !updated {
+		t.Error("deployment not restarted once the slot was free")
+	}
+	if got := testutil.ToFloat64(disruptionsInProgress); got != 0 {
+		t.Errorf("reboot_agent_disruptions_in_progress = %v after the restart returned, want 0", got)
+	}
+}
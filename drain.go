@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// drainFilter limits which namespaces a drain evicts pods from. Pods in namespaces that aren't
+// included, or are excluded, stay on the node through the reboot unless failOnUndrainable is
+// set, which aborts the drain instead. Exclusion wins over inclusion.
+type drainFilter struct {
+	include           map[string]bool // Empty includes every namespace
+	exclude           map[string]bool
+	failOnUndrainable bool
+}
+
+// Helper function to check whether the filter lets the drain evict pods from a namespace
+func (f drainFilter) allows(namespace string) bool {
+	if f.exclude[namespace] {
+		return false
+	}
+	return len(f.include) == 0 || f.include[namespace]
+}
+
+// Helper function to parse a comma-separated namespace list into a set
+func parseNamespaceSet(list string) (map[string]bool, error) {
+	set := map[string]bool{}
+	for _, namespace := range strings.Split(list, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, "; "))
+		}
+		set[namespace] = true
+	}
+	return set, nil
+}
+
+// Helper function to get the drain timeout for a node: its drain-timeout annotation if set to
+// a positive duration, else the global --drain-timeout. Invalid values are logged and ignored.
+func nodeDrainTimeout(logger *slog.Logger, node *v1.Node, keys AnnotationKeys, defaultTimeout time.Duration) time.Duration {
+	value, exists := node.Annotations[keys.DrainTimeout]
+	if !exists {
+		return defaultTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		logger.Warn("Ignoring invalid drain timeout annotation, using the default", "annotation", keys.DrainTimeout, "value", value, "default", defaultTimeout)
+		return defaultTimeout
+	}
+	return timeout
+}
+
+// drainLimiter bounds how many drains run at once, apart from the reboot limit, so drains
+// overlapping through requeues can't burst evictions at the apiserver. A nil drainLimiter
+// doesn't limit.
+type drainLimiter struct {
+	slots chan struct{}
+}
+
+// newDrainLimiter returns a limiter allowing max drains at once, or nil for no limit if max is 0
+func newDrainLimiter(max int) *drainLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &drainLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a free slot until ctx is done. The returned func releases the slot.
+func (l *drainLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// errEvictionsTimedOut is wrapped in the error drainNode returns when ctx ran out while evicted
+// pods were still on the node, which --drain-force takes as the cue to delete them
+var errEvictionsTimedOut = errors.New("evicted pods still on the node when the drain timed out")
+
+// How often drainNode retries evictions blocked by a PodDisruptionBudget and checks whether
+// evicted pods are gone. A variable so tests don't wait out real polls.
+var drainPollInterval = 5 * time.Second
+
+// drainNode evicts the pods bound to the node through the Eviction API, so PodDisruptionBudgets
+// are respected, and waits until they are gone. DaemonSet-owned and mirror pods are skipped as
+// they can't be moved off the node, as are pods in namespaces the filter leaves out. Evictions
+// go through the policy version evictions finds the cluster serves. The caller holds a slot from
+// the drain limiter. Gives up when ctx is done, wrapping errEvictionsTimedOut if evictions were
+// under way. In dry-run mode the pods that would be evicted are only logged.
+func drainNode(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, evictions *evictionAPI, nodeName string, filter drainFilter, apiTimeout time.Duration, dryRun bool) error {
+	if dryRun {
+		pods, err := listNodePods(ctx, client, nodeName, apiTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+		}
+		if err := checkUndrainable(logger, nodeName, pods.Items, filter); err != nil {
+			return err
+		}
+		for i := range pods.Items {
+			if evictable(&pods.Items[i]) && filter.allows(pods.Items[i].Namespace) {
+				logger.Info("Dry run: would evict pod", "pod", pods.Items[i].Name, "namespace", pods.Items[i].Namespace)
+			}
+		}
+		return nil
+	}
+
+	version, err := evictions.policyVersion()
+	if err != nil {
+		return fmt.Errorf("failed to drain node %s: %w", nodeName, err)
+	}
+	drainsInProgress.Inc()
+	defer drainsInProgress.Dec()
+	evicting := false
+	err = evictUntilGone(ctx, client, version, nodeName, apiTimeout, func(pods []v1.Pod) ([]*v1.Pod, error) {
+		if err := checkUndrainable(logger, nodeName, pods, filter); err != nil {
+			return nil, err
+		}
+		var selected []*v1.Pod
+		for i := range pods {
+			if evictable(&pods[i]) && filter.allows(pods[i].Namespace) {
+				selected = append(selected, &pods[i])
+			}
+		}
+		evicting = evicting || len(selected) > 0
+		return selected, nil
+	})
+	if err != nil && evicting && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("failed to drain node %s: %w: %w", nodeName, errEvictionsTimedOut, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to drain node %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+// Helper function to evict the pods on the node that selected picks out, polling every
+// drainPollInterval to retry evictions a PodDisruptionBudget refused, until none of them are
+// left or ctx is done
+func evictUntilGone(ctx context.Context, client kubernetes.Interface, version, nodeName string, apiTimeout time.Duration, selected func(pods []v1.Pod) ([]*v1.Pod, error)) error {
+	return wait.PollUntilContextCancel(ctx, drainPollInterval, true, func(ctx context.Context) (bool, error) {
+		pods, err := listNodePods(ctx, client, nodeName, apiTimeout)
+		if err != nil {
+			return false, err
+		}
+		remaining, err := selected(pods.Items)
+		if err != nil {
+			return false, err
+		}
+		for _, pod := range remaining {
+			if pod.DeletionTimestamp != nil {
+				continue // Already evicted, waiting for it to terminate
+			}
+			if err := evictPod(ctx, client, version, pod, apiTimeout); err != nil {
+				return false, err
+			}
+		}
+		return len(remaining) == 0, nil
+	})
+}
+
+// nodeDrainEmpty reports whether draining the node would have nothing to do: every pod on it is
+// one a drain leaves in place, such as DaemonSet, mirror and finished pods
+func nodeDrainEmpty(ctx context.Context, client kubernetes.Interface, nodeName string, apiTimeout time.Duration) (bool, error) {
+	pods, err := listNodePods(ctx, client, nodeName, apiTimeout)
+	if err != nil {
+		return false, fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+	}
+	for i := range pods.Items {
+		if evictable(&pods.Items[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// nodeManuallyDraining reports whether the node is being drained outside the agent: it was
+// cordoned without the agent's marker and pods on it are terminating, as they are while
+// kubectl drain evicts them. Once they are gone the drain has settled, and a reboot can go
+// ahead on the node left cordoned.
+func nodeManuallyDraining(ctx context.Context, client kubernetes.Interface, node *v1.Node, keys AnnotationKeys, apiTimeout time.Duration) (bool, error) {
+	if !node.Spec.Unschedulable || cordonedByAgent(node, keys) {
+		return false, nil
+	}
+	pods, err := listNodePods(ctx, client, node.Name, apiTimeout)
+	if err != nil {
+		return false, fmt.Errorf("failed to list pods on node %s: %w", node.Name, err)
+	}
+	for i := range pods.Items {
+		if evictable(&pods.Items[i]) && pods.Items[i].DeletionTimestamp != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Helper function to find the pods the filter keeps the drain from evicting. They're logged, or
+// returned as an error if the filter is set to fail on them.
+func checkUndrainable(logger *slog.Logger, nodeName string, pods []v1.Pod, filter drainFilter) error {
+	var undrainable []string
+	for i := range pods {
+		if evictable(&pods[i]) && !filter.allows(pods[i].Namespace) {
+			undrainable = append(undrainable, pods[i].Namespace+"/"+pods[i].Name)
+		}
+	}
+	if len(undrainable) == 0 {
+		return nil
+	}
+	if filter.failOnUndrainable {
+		return fmt.Errorf("node %s has pods in namespaces excluded from the drain: %s", nodeName, strings.Join(undrainable, ", "))
+	}
+	logger.Debug("Leaving pods in namespaces excluded from the drain", "pods", undrainable)
+	return nil
+}
+
+// Helper function to list the pods bound to a node
+func listNodePods(ctx context.Context, client kubernetes.Interface, nodeName string, apiTimeout time.Duration) (*v1.PodList, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+	return client.CoreV1().Pods(v1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+}
+
+// Helper function to check whether a pod should be evicted during a drain
+func evictable(pod *v1.Pod) bool {
+	// Mirror pods are managed by the kubelet from static manifests and can't be evicted
+	if _, mirror := pod.Annotations[v1.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+	// DaemonSet pods would be recreated on the same node straight away
+	for _, ownerRef := range pod.OwnerReferences {
+		if ownerRef.Controller != nil && *ownerRef.Controller && ownerRef.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	// Finished pods no longer hold anything on the node
+	return pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed
+}
+
+// Helper function to request eviction of a pod through the given policy group version.
+// Evictions refused by a PodDisruptionBudget and pods that are already gone are not errors, the
+// pod is retried or dropped on the next poll.
+func evictPod(ctx context.Context, client kubernetes.Interface, version string, pod *v1.Pod, apiTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	var err error
+	meta := metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}
+	if version == evictionV1beta1 {
+		err = client.PolicyV1beta1().Evictions(pod.Namespace).Evict(ctx, &policyv1beta1.Eviction{ObjectMeta: meta})
+	} else {
+		err = client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{ObjectMeta: meta})
+	}
+	if apierrors.IsTooManyRequests(err) || apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	return nil
+}
+
+// forceDeletePods deletes the evictable pods still on the node, bypassing PodDisruptionBudgets.
+// Used with --drain-force once a drain has timed out; it doesn't wait for the pods to go.
+func forceDeletePods(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, nodeName string, filter drainFilter, apiTimeout time.Duration, dryRun bool) error {
+	pods, err := listNodePods(ctx, client, nodeName, apiTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !evictable(pod) || !filter.allows(pod.Namespace) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if dryRun {
+			logger.Info("Dry run: would force delete pod", "pod", pod.Name, "namespace", pod.Namespace)
+			continue
+		}
+		deleteCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+		err := client.CoreV1().Pods(pod.Namespace).Delete(deleteCtx, pod.Name, metav1.DeleteOptions{})
+		cancel()
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		logger.Warn("Force deleted pod", "pod", pod.Name, "namespace", pod.Namespace)
+	}
+	return nil
+}
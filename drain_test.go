@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func TestNodeDrainTimeout(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        time.Duration
+	}{
+		{"no annotation", nil, 5 * time.Minute},
+		{"override", map[string]string{keys.DrainTimeout: "20m"}, 20 * time.Minute},
+		{"invalid", map[string]string{keys.DrainTimeout: "soon"}, 5 * time.Minute},
+		{"zero", map[string]string{keys.DrainTimeout: "0s"}, 5 * time.Minute},
+		{"negative", map[string]string{keys.DrainTimeout: "-1m"}, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := testNode("node-1", tt.annotations)
+			if got := nodeDrainTimeout(discardLogger(), node, keys, 5*time.Minute); got != tt.want {
+				t.Errorf("nodeDrainTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Helper function to build a pod bound to a node, controlled by an owner of the given kind
+// (none if empty)
+func testPod(namespace, name, nodeName, ownerKind string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if ownerKind != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: ownerKind, Name: name + "-owner", Controller: ptr.To(true)}}
+	}
+	return pod
+}
+
+func TestFastPathEmptyNode(t *testing.T) {
+	keys := testKeys(t)
+	for _, fastPath := range []bool{true, false} {
+		node := testNode("node-1", map[string]string{keys.Reboot: ""})
+		c, client := newTestController(t, node, testPod("kube-system", "fluentd", "node-1", "DaemonSet"))
+		c.fastPathEmpty = fastPath
+
+		if err := c.syncNode(context.Background(), "node-1"); err != nil {
+			t.Fatalf("fast path %v: syncNode() failed: %v", fastPath, err)
+		}
+		got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rebootInProgress(got, keys) {
+			t.Errorf("fast path %v: reboot not started, annotations %v", fastPath, got.Annotations)
+		}
+		if got.Spec.Unschedulable == fastPath {
+			t.Errorf("fast path %v: node unschedulable = %v, want %v", fastPath, got.Spec.Unschedulable, !fastPath)
+		}
+		for _, action := range client.Actions() {
+			if action.GetSubresource() == "eviction" {
+				t.Errorf("fast path %v: DaemonSet pod was evicted", fastPath)
+			}
+		}
+	}
+}
+
+func TestDrainLimiter(t *testing.T) {
+	limiter := newDrainLimiter(1)
+	release, err := limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.acquire(ctx); err == nil {
+		t.Fatal("second acquire got a slot while the only one was held")
+	}
+
+	release()
+	release, err = limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire after release failed: %v", err)
+	}
+	release()
+
+	// No limit: a nil limiter always has a slot
+	var unlimited *drainLimiter
+	for i := 0; i < 3; i++ {
+		if _, err := unlimited.acquire(context.Background()); err != nil {
+			t.Fatalf("nil limiter acquire failed: %v", err)
+		}
+	}
+	if newDrainLimiter(0) != nil {
+		t.Error("newDrainLimiter(0) should not limit")
+	}
+}
+
+// Helper function to make evictions on the fake clientset delete the pod, or fail with err if
+// it is set, recording the evicted pods
+func reactToEvictions(t *testing.T, client *fake.Clientset, err error) *[]string {
+	t.Helper()
+	previous := drainPollInterval
+	drainPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { drainPollInterval = previous })
+
+	var evicted []string
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		evicted = append(evicted, eviction.Namespace+"/"+eviction.Name)
+		return true, nil, client.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+	})
+	return &evicted
+}
+
+func TestDrainNodeEvictsEligiblePods(t *testing.T) {
+	mirror := testPod("kube-system", "etcd", "node-1", "")
+	mirror.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "hash"}
+	finished := testPod("default", "migrate", "node-1", "Job")
+	finished.Status.Phase = v1.PodSucceeded
+	client := fake.NewSimpleClientset(
+		testPod("default", "web-1", "node-1", "ReplicaSet"),
+		testPod("default", "bare", "node-1", ""),
+		testPod("kube-system", "fluentd", "node-1", "DaemonSet"),
+		mirror,
+		finished,
+	)
+	evicted := reactToEvictions(t, client, nil)
+
+	if err := drainNode(context.Background(), discardLogger(), client, nil, "node-1", drainFilter{}, time.Second, false); err != nil {
+		t.Fatalf("drainNode() failed: %v", err)
+	}
+	sort.Strings(*evicted)
+	if want := []string{"default/bare", "default/web-1"}; !slices.Equal(*evicted, want) {
+		t.Errorf("evicted %v, want %v", *evicted, want)
+	}
+	if _, err := client.CoreV1().Pods("kube-system").Get(context.Background(), "fluentd", metav1.GetOptions{}); err != nil {
+		t.Errorf("DaemonSet pod was removed: %v", err)
+	}
+}
+
+func TestDrainOutcomes(t *testing.T) {
+	keys := testKeys(t)
+	blocked := apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+	tests := []struct {
+		name       string
+		evictErr   error
+		force      bool
+		rebooted   bool
+		podDeleted bool
+	}{
+		{"clean drain", nil, false, true, true},
+		{"blocked by a PodDisruptionBudget", blocked, false, false, false},
+		{"blocked, forced after the timeout", blocked, true, true, true},
+	}
+	for _, tt := range tests {
+		node := testNode("node-1", map[string]string{keys.Reboot: ""})
+		c, client := newTestController(t, node, testPod("default", "web-1", "node-1", "ReplicaSet"))
+		reactToEvictions(t, client, tt.evictErr)
+		c.drainTimeout = 50 * time.Millisecond
+		c.drainForce = tt.force
+
+		err := c.syncNode(context.Background(), "node-1")
+		if (err == nil) != tt.rebooted {
+			t.Errorf("%s: syncNode() error = %v, want the reboot to go ahead: %v", tt.name, err, tt.rebooted)
+		}
+		got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rebootInProgress(got, keys) != tt.rebooted {
+			t.Errorf("%s: reboot in progress = %v, want %v", tt.name, rebootInProgress(got, keys), tt.rebooted)
+		}
+		if !got.Spec.Unschedulable {
+			t.Errorf("%s: node uncordoned, want it left cordoned", tt.name)
+		}
+		_, err = client.CoreV1().Pods("default").Get(context.Background(), "web-1", metav1.GetOptions{})
+		if deleted := apierrors.IsNotFound(err); deleted != tt.podDeleted {
+			t.Errorf("%s: pod deleted = %v, want %v", tt.name, deleted, tt.podDeleted)
+		}
+	}
+}
+
+func TestDrainNamespaceFilter(t *testing.T) {
+	set := func(list string) map[string]bool {
+		namespaces, err := parseNamespaceSet(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return namespaces
+	}
+	tests := []struct {
+		name    string
+		filter  drainFilter
+		evicted []string
+		wantErr bool
+	}{
+		{"no filter", drainFilter{}, []string{"monitoring/prometheus", "team-a/web", "team-b/api"}, false},
+		{"exclude", drainFilter{exclude: set("monitoring")}, []string{"team-a/web", "team-b/api"}, false},
+		{"include", drainFilter{include: set("team-a, team-b")}, []string{"team-a/web", "team-b/api"}, false},
+		{"exclude wins", drainFilter{include: set("team-a,monitoring"), exclude: set("monitoring")}, []string{"team-a/web"}, false},
+		{"fail on undrainable", drainFilter{exclude: set("monitoring"), failOnUndrainable: true}, nil, true},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(
+			testPod("team-a", "web", "node-1", "ReplicaSet"),
+			testPod("team-b", "api", "node-1", "ReplicaSet"),
+			testPod("monitoring", "prometheus", "node-1", "StatefulSet"),
+		)
+		evicted := reactToEvictions(t, client, nil)
+
+		err := drainNode(context.Background(), discardLogger(), client, nil, "node-1", tt.filter, time.Second, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: drainNode() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		sort.Strings(*evicted)
+		if !slices.Equal(*evicted, tt.evicted) {
+			t.Errorf("%s: evicted %v, want %v", tt.name, *evicted, tt.evicted)
+		}
+	}
+
+	if _, err := parseNamespaceSet("team-a,Team_B"); err == nil {
+		t.Error("parseNamespaceSet() accepted an invalid namespace")
+	}
+}
+
+func TestDrainNodeTimedOut(t *testing.T) {
+	blocked := apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+	tests := []struct {
+		name     string
+		evictErr error
+		timedOut bool
+	}{
+		{"blocked by a PodDisruptionBudget", blocked, true},
+		{"eviction refused", apierrors.NewForbidden(v1.Resource("pods"), "web-1", nil), false},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(testPod("default", "web-1", "node-1", "ReplicaSet"))
+		reactToEvictions(t, client, tt.evictErr)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := drainNode(ctx, discardLogger(), client, nil, "node-1", drainFilter{}, time.Second, false)
+		cancel()
+		if err == nil {
+			t.Fatalf("%s: drainNode() succeeded, want an error", tt.name)
+		}
+		if errors.Is(err, errEvictionsTimedOut) != tt.timedOut {
+			t.Errorf("%s: drainNode() error = %v, want timed out evictions: %v", tt.name, err, tt.timedOut)
+		}
+	}
+}
+
+func TestDrainTimeoutStartsWithSlot(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	c, client := newTestController(t, node, testPod("default", "web-1", "node-1", "ReplicaSet"))
+	reactToEvictions(t, client, nil)
+	c.drainTimeout = 50 * time.Millisecond
+	c.drainForce = true
+	c.drainLimiter = newDrainLimiter(1)
+
+	// Hold the only slot for longer than the drain timeout
+	release, err := c.drainLimiter.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(150*time.Millisecond, release)
+
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatalf("syncNode() failed: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" && action.GetResource().Resource == "pods" {
+			t.Error("pod force deleted, want it evicted once the slot was free")
+		}
+	}
+}
+
+func TestDryRunDrainNotCounted(t *testing.T) {
+	client := fake.NewSimpleClientset(testPod("default", "web-1", "node-1", "ReplicaSet"))
+	var during float64
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		during = testutil.ToFloat64(drainsInProgress)
+		return false, nil, nil
+	})
+	before := testutil.ToFloat64(drainsInProgress)
+	if err := drainNode(context.Background(), discardLogger(), client, nil, "node-1", drainFilter{}, time.Second, true); err != nil {
+		t.Fatalf("drainNode() failed: %v", err)
+	}
+	if during != before {
+		t.Errorf("drains_in_progress = %v during a dry-run drain, want %v", during, before)
+	}
+}
+
+func TestManualDrainDefersReboot(t *testing.T) {
+	keys := testKeys(t)
+	terminating := testPod("default", "web-1", "node-1", "ReplicaSet")
+	terminating.DeletionTimestamp = ptr.To(metav1.Now())
+	terminating.Finalizers = []string{"example.com/hold"}
+	tests := []struct {
+		name          string
+		unschedulable bool
+		annotations   map[string]string
+		pods          []runtime.Object
+		deferred      bool
+	}{
+		{"manual drain in progress", true, nil, []runtime.Object{terminating}, true},
+		{"manual drain settled", true, nil, nil, false},
+		{"cordoned by the agent", true, map[string]string{keys.CordonedByAgent: ""}, nil, false},
+		{"not cordoned", false, nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{keys.Reboot: ""}
+			for key, value := range tt.annotations {
+				annotations[key] = value
+			}
+			node := testNode("node-1", annotations)
+			node.Spec.Unschedulable = tt.unschedulable
+			c, client := newTestController(t, append(tt.pods, node)...)
+
+			err := c.syncNode(context.Background(), "node-1")
+			var requeue *requeueError
+			if errors.As(err, &requeue) != tt.deferred {
+				t.Fatalf("syncNode() error = %v, want the reboot deferred: %v", err, tt.deferred)
+			}
+			if !tt.deferred && err != nil {
+				t.Fatalf("syncNode() failed: %v", err)
+			}
+			got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rebootInProgress(got, keys) == tt.deferred {
+				t.Errorf("reboot in progress = %v, want %v", rebootInProgress(got, keys), !tt.deferred)
+			}
+			if !tt.deferred {
+				return
+			}
+			// The deferred reboot hands back its slot, and says why it waits
+			if !c.rebootLimiter.tryAcquire("node-2", "") {
+				t.Error("deferred reboot kept its slot")
+			}
+			if event := <-c.recorder.(*record.FakeRecorder).Events; !strings.Contains(event, eventManualDrainDetected) {
+				t.Errorf("event = %q, want %s", event, eventManualDrainDetected)
+			}
+			if decision, _ := c.decisions.get("node-1"); decision.reason != reasonManualDrain {
+				t.Errorf("decision = %q, want %q", decision.reason, reasonManualDrain)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Event source component, shown in the From column of `kubectl describe node`
+const eventComponent = "reboot-agent"
+
+// Reasons for the Events recorded on a Node as it goes through a reboot
+const (
+	eventRebootRequested  = "RebootRequested"
+	eventRebootInProgress = "RebootInProgress"
+	eventRebootCompleted  = "RebootCompleted"
+	eventRebootFailed     = "RebootFailed"
+	// Recorded when --wait-for-reschedule gives up waiting and the reboot goes ahead
+	eventRescheduleTimedOut = "RescheduleTimedOut"
+	// Recorded when a reboot is deferred while someone else drains the node
+	eventManualDrainDetected = "ManualDrainDetected"
+)
+
+// Reason for the Event recorded on a pod whose workload restart was skipped for the cooldown
+const eventRestartSkipped = "RestartSkipped"
+
+// Reason for the Event recorded on a Node when /drain evicts some of its pods
+const eventPartialDrain = "PartialDrain"
+
+// newEventRecorder creates a recorder that writes Events through the clientset, or in dry-run
+// mode only logs them. The returned broadcaster must be shut down on exit to flush pending events.
+func newEventRecorder(logger *slog.Logger, clientset kubernetes.Interface, dryRun bool) (record.EventRecorder, record.EventBroadcaster) {
+	broadcaster := record.NewBroadcaster()
+	if dryRun {
+		broadcaster.StartEventWatcher(func(event *v1.Event) {
+			logger.Info("Dry run: would record event", "kind", event.InvolvedObject.Kind, "name", event.InvolvedObject.Name, "type", event.Type, "reason", event.Reason, "message", event.Message)
+		})
+	} else {
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	}
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+	return recorder, broadcaster
+}
+
+// rebootRecorder appends the reboot ID and reason to every event message, so each Event of a
+// reboot cycle says which reboot it belongs to and why the node is rebooting. The ID is left
+// out when there is none, e.g. for reboots started before IDs were recorded.
+type rebootRecorder struct {
+	record.EventRecorder
+	rebootID     string
+	rebootReason RebootReason
+}
+
+// Helper function to build the suffix added to event messages
+func (r rebootRecorder) suffix() string {
+	if r.rebootID == "" {
+		return " (reason: " + r.rebootReason.String() + ")"
+	}
+	return " (reboot " + r.rebootID + ", reason: " + r.rebootReason.String() + ")"
+}
+
+func (r rebootRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, message+r.suffix())
+}
+
+func (r rebootRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.Eventf(object, eventtype, reason, "%s%s", fmt.Sprintf(messageFmt, args...), r.suffix())
+}
+
+func (r rebootRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s%s", fmt.Sprintf(messageFmt, args...), r.suffix())
+}
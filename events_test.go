@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// failingRebooter fails every reboot with err
+type failingRebooter struct {
+	err error
+}
+
+func (r failingRebooter) Reboot(ctx context.Context, node *v1.Node) error {
+	return r.err
+}
+
+func (r failingRebooter) Verify(ctx context.Context, node *v1.Node) error {
+	return r.err
+}
+
+// Helper function to take the events recorded so far, in order
+func recordedEvents(recorder record.EventRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.(*record.FakeRecorder).Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestRebootCycleEvents(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	node.Status.NodeInfo.BootID = "boot-1"
+	c, client := newTestController(t, node)
+	rebootCycle(t, c, client, "node-1")
+
+	events := recordedEvents(c.recorder)
+	want := []struct{ prefix, annotation string }{
+		{"Normal " + eventRebootRequested + " ", keys.Reboot},
+		{"Normal " + eventRebootInProgress + " ", keys.RebootInProgress},
+		{"Normal " + eventRebootCompleted + " ", keys.RebootInProgress},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("recorded %d events, want %d: %q", len(events), len(want), events)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(events[i], w.prefix) || !strings.Contains(events[i], w.annotation) {
+			t.Errorf("event %d = %q, want %q mentioning %s", i, events[i], w.prefix, w.annotation)
+		}
+	}
+}
+
+func TestRebootFailedEvent(t *testing.T) {
+	keys := testKeys(t)
+	c, _ := newTestController(t, testNode("node-1", map[string]string{keys.Reboot: ""}))
+	c.rebooter = failingRebooter{err: errors.New("connection refused")}
+
+	if err := c.syncNode(context.Background(), "node-1"); err == nil {
+		t.Fatal("syncNode() succeeded with a failing rebooter")
+	}
+	events := recordedEvents(c.recorder)
+	if len(events) == 0 || !strings.HasPrefix(events[len(events)-1], "Warning "+eventRebootFailed+" ") || !strings.Contains(events[len(events)-1], "connection refused") {
+		t.Errorf("events = %q, want a final Warning %s with the error", events, eventRebootFailed)
+	}
+}
+
+func TestRebootReasonInEvents(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{"payload", map[string]string{keys.Reboot: `{"reason":"kernel-update"}`}, ReasonKernelUpdate},
+		{"payload detail", map[string]string{keys.Reboot: `{"reason":"KernelUpdate","detail":"6.8.0-45"}`}, ReasonKernelUpdate + ", detail: 6.8.0-45"},
+		{"annotation", map[string]string{keys.Reboot: "", keys.RebootReason: "security-patch"}, ReasonSecurityPatch},
+		{"payload wins", map[string]string{keys.Reboot: `{"reason":"kernel-update"}`, keys.RebootReason: "security-patch"}, ReasonKernelUpdate},
+		{"unknown", map[string]string{keys.Reboot: `{"reason":"disk firmware"}`}, ReasonOther + ", detail: disk firmware"},
+		{"none", map[string]string{keys.Reboot: ""}, ReasonOther},
+	}
+	for _, tt := range tests {
+		node := testNode("node-1", tt.annotations)
+		node.Status.NodeInfo.BootID = "boot-1"
+		c, client := newTestController(t, node)
+		rebootCycle(t, c, client, "node-1")
+
+		// The completion event comes after the payload is gone with the reboot annotation
+		events := recordedEvents(c.recorder)
+		if len(events) != 3 {
+			t.Fatalf("%s: recorded %d events, want 3: %q", tt.name, len(events), events)
+		}
+		for _, event := range events {
+			if !strings.HasSuffix(event, "reason: "+tt.want+")") {
+				t.Errorf("%s: event %q doesn't end with the reason %s", tt.name, event, tt.want)
+			}
+		}
+	}
+}
+
+func TestRebootIDInEvents(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	node.Status.NodeInfo.BootID = "boot-1"
+	c, client := newTestController(t, node)
+	rebootCycle(t, c, client, "node-1")
+
+	// Every event of the cycle, from the request on, names the same reboot
+	events := recordedEvents(c.recorder)
+	if len(events) != 3 {
+		t.Fatalf("recorded %d events, want 3: %q", len(events), events)
+	}
+	var rebootID string
+	for _, event := range events {
+		_, suffix, found := strings.Cut(event, "(reboot ")
+		id, _, _ := strings.Cut(suffix, ",")
+		if !found || id == "" {
+			t.Fatalf("event %q doesn't name the reboot", event)
+		}
+		if rebootID == "" {
+			rebootID = id
+		}
+		if id != rebootID {
+			t.Errorf("event %q names reboot %s, want %s", event, id, rebootID)
+		}
+	}
+
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, exists := got.Annotations[keys.RebootID]; exists {
+		t.Errorf("%s annotation %q left after the reboot", keys.RebootID, id)
+	}
+}
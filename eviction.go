@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/client-go/discovery"
+)
+
+// The policy group versions serving the Eviction API. Clusters before 1.22 only serve v1beta1.
+const (
+	evictionV1      = "v1"
+	evictionV1beta1 = "v1beta1"
+)
+
+// evictionAPI finds the policy group version the cluster serves the Eviction API under through
+// discovery, the way kubectl drain does. The result is cached after the first successful lookup,
+// a failed one is retried by the next drain. A nil evictionAPI uses policy/v1.
+type evictionAPI struct {
+	discovery discovery.DiscoveryInterface
+
+	mu      sync.Mutex
+	version string
+}
+
+// newEvictionAPI returns an evictionAPI looking up the version through the discovery client
+func newEvictionAPI(client discovery.DiscoveryInterface) *evictionAPI {
+	return &evictionAPI{discovery: client}
+}
+
+// policyVersion returns the policy group version to send Evictions with
+func (e *evictionAPI) policyVersion() (string, error) {
+	if e == nil {
+		return evictionV1, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.version != "" {
+		return e.version, nil
+	}
+
+	// The pods/eviction subresource lists the group version it's served under
+	resources, err := e.discovery.ServerResourcesForGroupVersion("v1")
+	if err != nil {
+		return "", fmt.Errorf("failed to discover the Eviction API: %w", err)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name != "pods/eviction" || resource.Group != policyv1.GroupName {
+			continue
+		}
+		switch resource.Version {
+		case evictionV1, evictionV1beta1:
+			e.version = resource.Version
+			return e.version, nil
+		default:
+			return "", fmt.Errorf("unsupported Eviction API version %s/%s", resource.Group, resource.Version)
+		}
+	}
+	return "", fmt.Errorf("the cluster doesn't serve the Eviction API")
+}
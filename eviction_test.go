@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Helper function to have the fake clientset's discovery serve pods/eviction under a policy
+// version, or not at all if version is empty
+func serveEvictionVersion(client *fake.Clientset, version string) {
+	resources := []metav1.APIResource{{Name: "pods", Kind: "Pod", Namespaced: true}}
+	if version != "" {
+		resources = append(resources, metav1.APIResource{Name: "pods/eviction", Kind: "Eviction", Namespaced: true, Group: "policy", Version: version})
+	}
+	client.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: resources},
+	}
+}
+
+func TestEvictionAPIVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		served  string
+		want    string
+		wantErr bool
+	}{
+		{"policy/v1", evictionV1, evictionV1, false},
+		{"policy/v1beta1 only", evictionV1beta1, evictionV1beta1, false},
+		{"not served", "", "", true},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(testPod("default", "web-1", "node-1", "ReplicaSet"))
+		serveEvictionVersion(client, tt.served)
+		previous := drainPollInterval
+		drainPollInterval = 10 * time.Millisecond
+		t.Cleanup(func() { drainPollInterval = previous })
+
+		// Record the version each Eviction was sent as
+		var sent []string
+		client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "eviction" {
+				return false, nil, nil
+			}
+			var meta metav1.ObjectMeta
+			switch eviction := action.(k8stesting.CreateAction).GetObject().(type) {
+			case *policyv1.Eviction:
+				sent, meta = append(sent, evictionV1), eviction.ObjectMeta
+			case *policyv1beta1.Eviction:
+				sent, meta = append(sent, evictionV1beta1), eviction.ObjectMeta
+			}
+			return true, nil, client.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), meta.Namespace, meta.Name)
+		})
+
+		evictions := newEvictionAPI(client.Discovery())
+		err := drainNode(context.Background(), discardLogger(), client, evictions, "node-1", drainFilter{}, time.Second, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: drainNode() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(sent) != 1 || sent[0] != tt.want {
+			t.Errorf("%s: sent Evictions as %v, want one as %s", tt.name, sent, tt.want)
+		}
+
+		// The version found is kept even if discovery changes its answer
+		serveEvictionVersion(client, "")
+		if got, err := evictions.policyVersion(); err != nil || got != tt.want {
+			t.Errorf("%s: cached policyVersion() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
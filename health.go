@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// serveHealth serves the probe endpoints on addr until stopCh closes. /healthz succeeds as
+// soon as the process is up; /readyz only once ready is set after the caches have synced. Both
+// fail while an informer's watch keeps failing. Returns an error if addr can't be bound.
+func serveHealth(logger *slog.Logger, addr string, ready *atomic.Bool, watches *watchHealth, stopCh <-chan struct{}) error {
+	return serveHTTP(logger, "health", addr, healthMux(ready, watches), stopCh)
+}
+
+// Helper function to build the handler for the probe endpoints
+func healthMux(ready *atomic.Bool, watches *watchHealth) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if healthy, informer := watches.healthy(); !healthy {
+			http.Error(w, "watch failing for informer "+informer, http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "informer caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+		if healthy, informer := watches.healthy(); !healthy {
+			http.Error(w, "watch failing for informer "+informer, http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
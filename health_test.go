@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Helper function to GET a path from a handler, returning the status code
+func probe(handler http.Handler, path string) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code
+}
+
+func TestHealthProbes(t *testing.T) {
+	var ready atomic.Bool
+	watches := newWatchHealth(2, time.Minute)
+	mux := healthMux(&ready, watches)
+
+	if got := probe(mux, "/healthz"); got != http.StatusOK {
+		t.Errorf("/healthz before sync = %d, want 200", got)
+	}
+	if got := probe(mux, "/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before sync = %d, want 503", got)
+	}
+
+	ready.Store(true) // The caches synced
+	if got := probe(mux, "/readyz"); got != http.StatusOK {
+		t.Errorf("/readyz after sync = %d, want 200", got)
+	}
+
+	// Repeated watch failures fail both probes
+	handler := watches.handler(discardLogger(), "nodes")
+	handler(newTestReflector(), errors.New("connection refused"))
+	handler(newTestReflector(), errors.New("connection refused"))
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if got := probe(mux, path); got != http.StatusServiceUnavailable {
+			t.Errorf("%s with a failing watch = %d, want 503", path, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Function to restart a Job-owned pod. Jobs have no rollout to trigger, so the pod is deleted
+// for the Job to recreate. Pods of finished Jobs, of Jobs out of retries, and of CronJob runs
+// are left alone. Reports whether the pod was deleted. The logger is expected to carry the
+// Job's kind and name.
+func restartJobPod(ctx context.Context, logger *slog.Logger, clientset kubernetes.Interface, owner metav1.OwnerReference, pod *v1.Pod, dryRun bool) (bool, error) {
+	job, err := clientset.BatchV1().Jobs(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get job %s: %w", owner.Name, err)
+	}
+
+	// A CronJob run is short-lived and the next run starts fresh, so don't disturb it
+	for _, ref := range job.OwnerReferences {
+		if ref.Kind == "CronJob" {
+			logger.Info("Job belongs to a CronJob, leaving its pod alone", "cronjob", ref.Name)
+			return false, nil
+		}
+	}
+	if jobFinished(job) {
+		logger.Warn("Job has finished, it won't recreate the pod, skipping restart")
+		return false, nil
+	}
+	// The deleted pod counts as a failure, which could push the Job over its backoff limit
+	if job.Spec.BackoffLimit != nil && job.Status.Failed+1 > *job.Spec.BackoffLimit {
+		logger.Warn("Job has no retries left, skipping restart", "failed", job.Status.Failed, "backoffLimit", *job.Spec.BackoffLimit)
+		return false, nil
+	}
+
+	if dryRun {
+		logger.Info("Dry run: would delete job pod", "pod", pod.Name)
+		return false, nil
+	}
+	err = clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil // Already gone
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete pod %s: %w", pod.Name, err)
+	}
+	logger.Info("Deleted job pod for the job to recreate", "pod", pod.Name)
+	return true, nil
+}
+
+// Helper function to check whether a Job has completed or failed
+func jobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestRestartJobPod(t *testing.T) {
+	job := func(mutate func(*batchv1.Job)) *batchv1.Job {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "migrate"}}
+		if mutate != nil {
+			mutate(job)
+		}
+		return job
+	}
+	tests := []struct {
+		name    string
+		job     *batchv1.Job
+		deleted bool
+	}{
+		{"running job", job(nil), true},
+		{"cronjob run", job(func(job *batchv1.Job) {
+			job.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: "nightly", Controller: ptr.To(true)}}
+		}), false},
+		{"finished job", job(func(job *batchv1.Job) {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: v1.ConditionTrue}}
+		}), false},
+		{"out of retries", job(func(job *batchv1.Job) {
+			job.Spec.BackoffLimit = ptr.To(int32(2))
+			job.Status.Failed = 2
+		}), false},
+	}
+	for _, tt := range tests {
+		pod := testPod("default", "migrate-x", "node-1", "")
+		client := fake.NewSimpleClientset(tt.job, pod)
+		owner := metav1.OwnerReference{APIVersion: "batch/v1", Kind: "Job", Name: "migrate"}
+
+		deleted, err := restartJobPod(context.Background(), discardLogger(), client, owner, pod, false)
+		if err != nil {
+			t.Fatalf("%s: restartJobPod() failed: %v", tt.name, err)
+		}
+		_, getErr := client.CoreV1().Pods("default").Get(context.Background(), "migrate-x", metav1.GetOptions{})
+		if deleted != tt.deleted || (getErr != nil) != tt.deleted {
+			t.Errorf("%s: deleted = %v (pod lookup error %v), want %v", tt.name, deleted, getErr, tt.deleted)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// JSONPatchOperation is one operation of an RFC 6902 JSON Patch
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Escapes a key for use as a JSON Pointer reference token, per RFC 6901: ~ must be escaped
+// before / so the ~ introduced by escaping / isn't escaped again
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// buildAnnotationJSONPatch builds the operations setting the adds and removing the removes
+// from metadata.annotations, in a stable order. Every key removed must exist and the
+// annotations map itself must exist, or the apiserver rejects the whole patch.
+func buildAnnotationJSONPatch(adds map[string]string, removes []string) []JSONPatchOperation {
+	keys := make([]string, 0, len(adds))
+	for key := range adds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	removes = append([]string(nil), removes...)
+	sort.Strings(removes)
+
+	operations := make([]JSONPatchOperation, 0, len(adds)+len(removes))
+	for _, key := range keys {
+		// add replaces the value of a key that is already there
+		operations = append(operations, JSONPatchOperation{Op: "add", Path: annotationPath(key), Value: adds[key]})
+	}
+	for _, key := range removes {
+		operations = append(operations, JSONPatchOperation{Op: "remove", Path: annotationPath(key)})
+	}
+	return operations
+}
+
+// Helper function to build the JSON Pointer to an annotation
+func annotationPath(key string) string {
+	return "/metadata/annotations/" + jsonPointerEscaper.Replace(key)
+}
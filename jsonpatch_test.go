@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnnotationPathEscaping(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"reboot", "/metadata/annotations/reboot"},
+		{"reboot-agent/reboot", "/metadata/annotations/reboot-agent~1reboot"},
+		{"odd~key", "/metadata/annotations/odd~0key"},
+		// ~ is escaped first, so the ~ from escaping / isn't escaped again
+		{"a~/b", "/metadata/annotations/a~0~1b"},
+		{"~1", "/metadata/annotations/~01"},
+	}
+	for _, tt := range tests {
+		if got := annotationPath(tt.key); got != tt.want {
+			t.Errorf("annotationPath(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestBuildAnnotationJSONPatch(t *testing.T) {
+	operations := buildAnnotationJSONPatch(
+		map[string]string{"reboot-agent/reboot-in-progress": "2024-01-01T00:00:00Z", "reboot-agent/boot-id": ""},
+		[]string{"reboot-agent/reboot", "odd~key"},
+	)
+	want := []JSONPatchOperation{
+		{Op: "add", Path: "/metadata/annotations/reboot-agent~1boot-id", Value: ""},
+		{Op: "add", Path: "/metadata/annotations/reboot-agent~1reboot-in-progress", Value: "2024-01-01T00:00:00Z"},
+		{Op: "remove", Path: "/metadata/annotations/odd~0key"},
+		{Op: "remove", Path: "/metadata/annotations/reboot-agent~1reboot"},
+	}
+	if !reflect.DeepEqual(operations, want) {
+		t.Fatalf("buildAnnotationJSONPatch() = %+v, want %+v", operations, want)
+	}
+
+	// The escaped paths land on the right keys when applied, empty values included
+	client := fake.NewSimpleClientset(testNode("node-1", map[string]string{"reboot-agent/reboot": "", "odd~key": "x", "team": "payments"}))
+	patch, err := json.Marshal(operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := client.CoreV1().Nodes().Patch(context.Background(), "node-1", types.JSONPatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		t.Fatalf("applying the patch failed: %v", err)
+	}
+	wantAnnotations := map[string]string{"reboot-agent/reboot-in-progress": "2024-01-01T00:00:00Z", "reboot-agent/boot-id": "", "team": "payments"}
+	if !reflect.DeepEqual(node.Annotations, wantAnnotations) {
+		t.Errorf("annotations after the patch = %v, want %v", node.Annotations, wantAnnotations)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Data keys of the annotation ConfigMap. Each names the full annotation key to use in place of
+// the one derived from --annotation-prefix; entries left out keep that default.
+const (
+	configMapRebootKey           = "reboot"
+	configMapRebootNeededKey     = "reboot-needed"
+	configMapRebootInProgressKey = "reboot-in-progress"
+)
+
+// keysFromConfigMap returns keys with the annotation keys the ConfigMap sets in their place
+func keysFromConfigMap(keys AnnotationKeys, configMap *v1.ConfigMap) (AnnotationKeys, error) {
+	for dataKey, key := range map[string]*string{
+		configMapRebootKey:           &keys.Reboot,
+		configMapRebootNeededKey:     &keys.RebootNeeded,
+		configMapRebootInProgressKey: &keys.RebootInProgress,
+	} {
+		value, exists := configMap.Data[dataKey]
+		if !exists {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if errs := validation.IsQualifiedName(value); len(errs) > 0 {
+			return AnnotationKeys{}, fmt.Errorf("invalid %s annotation key %q: %s", dataKey, value, strings.Join(errs, "; "))
+		}
+		*key = value
+	}
+	if keys.Reboot == keys.RebootNeeded || keys.Reboot == keys.RebootInProgress || keys.RebootNeeded == keys.RebootInProgress {
+		return AnnotationKeys{}, fmt.Errorf("the reboot, reboot-needed and reboot-in-progress annotation keys must differ")
+	}
+	return keys, nil
+}
+
+// parseConfigMapRef splits a namespace/name reference to a ConfigMap
+func parseConfigMapRef(ref string) (string, string, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(ref)
+	if err != nil || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("want namespace/name, got %q", ref)
+	}
+	return namespace, name, nil
+}
+
+// loadAnnotationConfigMap reads the annotation ConfigMap at startup and returns keys with its
+// overrides applied. A missing ConfigMap leaves keys as they are, it is picked up once created.
+func loadAnnotationConfigMap(ctx context.Context, client kubernetes.Interface, namespace, name string, keys AnnotationKeys, apiTimeout time.Duration) (AnnotationKeys, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return AnnotationKeys{}, fmt.Errorf("failed to get annotation ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	return keysFromConfigMap(keys, configMap)
+}
+
+// newAnnotationConfigMapInformer returns an informer watching only the annotation ConfigMap
+func newAnnotationConfigMapInformer(client kubernetes.Interface, namespace, name string, resync time.Duration) (informers.SharedInformerFactory, cache.SharedIndexInformer) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+	return factory, factory.Core().V1().ConfigMaps().Informer()
+}
+
+// WatchAnnotationConfigMap has the controller follow changes to the annotation ConfigMap,
+// falling back to defaults, the keys from the flags, if it is deleted. The reboot and
+// reboot-needed keys change live, as they only decide which nodes ask for a reboot. The
+// reboot-in-progress key marks the reboots under way, so a change to it is only logged and
+// takes effect when the agent restarts.
+func (c *Controller) WatchAnnotationConfigMap(informer cache.SharedIndexInformer, defaults AnnotationKeys) error {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.applyAnnotationConfigMap(obj.(*v1.ConfigMap), defaults)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.applyAnnotationConfigMap(newObj.(*v1.ConfigMap), defaults)
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.applyAnnotationConfigMap(&v1.ConfigMap{}, defaults)
+		},
+	})
+	return err
+}
+
+// Helper function to switch to the request keys a version of the annotation ConfigMap sets.
+// Every node and pod is requeued on a change, as those already carrying the new keys only ask
+// for their reboot or restart once looked at again.
+func (c *Controller) applyAnnotationConfigMap(configMap *v1.ConfigMap, defaults AnnotationKeys) {
+	keys, err := keysFromConfigMap(defaults, configMap)
+	if err != nil {
+		c.logger.Error("Ignoring invalid annotation ConfigMap", "configmap", configMap.Namespace+"/"+configMap.Name, "error", err)
+		return
+	}
+
+	c.keysMu.Lock()
+	current := c.keys
+	changed := keys.Reboot != current.Reboot || keys.RebootNeeded != current.RebootNeeded
+	c.keys.Reboot, c.keys.RebootNeeded = keys.Reboot, keys.RebootNeeded
+	c.keysMu.Unlock()
+
+	if keys.RebootInProgress != current.RebootInProgress {
+		c.logger.Warn("Annotation ConfigMap changes the reboot-in-progress key, which takes effect on restart", "current", current.RebootInProgress, "configured", keys.RebootInProgress)
+	}
+	if !changed {
+		return
+	}
+	c.logger.Info("Annotation keys changed", "reboot", keys.Reboot, "reboot-needed", keys.RebootNeeded)
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error("Failed to list nodes to recheck against the new annotation keys", "error", err)
+		return
+	}
+	for _, node := range nodes {
+		c.nodeQueue.Add(node.Name)
+	}
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error("Failed to list pods to recheck against the new annotation keys", "error", err)
+		return
+	}
+	for _, pod := range pods {
+		c.enqueue(c.podQueue, pod)
+	}
+}
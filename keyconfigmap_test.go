@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestKeysFromConfigMap(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name    string
+		data    map[string]string
+		want    AnnotationKeys
+		wantErr bool
+	}{
+		{"empty", nil, keys, false},
+		{"partial", map[string]string{"reboot": "example.com/reboot"}, func() AnnotationKeys {
+			want := keys
+			want.Reboot = "example.com/reboot"
+			return want
+		}(), false},
+		{"all", map[string]string{"reboot": "example.com/reboot", "reboot-needed": "example.com/needed", "reboot-in-progress": " example.com/rebooting "}, func() AnnotationKeys {
+			want := keys
+			want.Reboot, want.RebootNeeded, want.RebootInProgress = "example.com/reboot", "example.com/needed", "example.com/rebooting"
+			return want
+		}(), false},
+		{"invalid", map[string]string{"reboot": "not a key"}, AnnotationKeys{}, true},
+		{"clashing", map[string]string{"reboot": keys.RebootNeeded}, AnnotationKeys{}, true},
+	}
+	for _, tt := range tests {
+		got, err := keysFromConfigMap(keys, &v1.ConfigMap{Data: tt.data})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: keysFromConfigMap() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("%s: keysFromConfigMap() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, ref := range []string{"reboot-keys", "/reboot-keys", "kube-system/"} {
+		if _, _, err := parseConfigMapRef(ref); err == nil {
+			t.Errorf("parseConfigMapRef(%q) succeeded, want an error", ref)
+		}
+	}
+}
+
+func TestAnnotationConfigMapChangesKeysLive(t *testing.T) {
+	keys := testKeys(t)
+	c, client := newTestController(t, testNode("node-1", map[string]string{"example.com/reboot": ""}))
+	factory, informer := newAnnotationConfigMapInformer(client, "kube-system", "reboot-keys", 0)
+	if err := c.WatchAnnotationConfigMap(informer, keys); err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	factory.Start(stopCh)
+	cache.WaitForCacheSync(stopCh, informer.HasSynced)
+
+	waitForKeys := func(what string, done func(AnnotationKeys) bool) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			return done(c.annotationKeys()), nil
+		})
+		if err != nil {
+			t.Fatalf("%s: keys are %+v", what, c.annotationKeys())
+		}
+	}
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "reboot-keys"},
+		Data:       map[string]string{"reboot": "example.com/reboot", "reboot-in-progress": "example.com/rebooting"},
+	}
+	if _, err := client.CoreV1().ConfigMaps("kube-system").Create(context.Background(), configMap, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForKeys("reboot key never changed", func(current AnnotationKeys) bool { return current.Reboot == "example.com/reboot" })
+	if got := c.annotationKeys().RebootInProgress; got != keys.RebootInProgress {
+		t.Errorf("reboot-in-progress key changed live to %s, want %s until restart", got, keys.RebootInProgress)
+	}
+	// The node already carrying the new key is looked at again
+	if c.nodeQueue.Len() == 0 {
+		t.Error("no node requeued after the reboot key changed")
+	}
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := nodesInProgress(t, client, keys); len(got) != 1 {
+		t.Errorf("nodes rebooting = %v, want node-1 rebooting on the new key", got)
+	}
+
+	// Deleting the ConfigMap falls back to the keys from the flags
+	if err := client.CoreV1().ConfigMaps("kube-system").Delete(context.Background(), "reboot-keys", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForKeys("keys never fell back", func(current AnnotationKeys) bool { return current == keys })
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Returned when the agent is neither running in a cluster nor able to find a kubeconfig
+var errNoKubeconfig = errors.New("not running in a cluster and no kubeconfig found")
+
+// buildRestConfig returns the in-cluster config when running as a Pod, and otherwise falls
+// back to a kubeconfig: the --kubeconfig path if set, then $KUBECONFIG, then ~/.kube/config.
+// Naming a kubeconfig context always uses the kubeconfig, even in a cluster.
+func buildRestConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	if kubeContext == "" {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			return config, nil
+		}
+		if !errors.Is(err, rest.ErrNotInCluster) {
+			return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+		}
+	}
+
+	// The default loading rules already honor $KUBECONFIG and ~/.kube/config
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+
+	if kubeContext != "" {
+		raw, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
+		if _, ok := raw.Contexts[kubeContext]; !ok {
+			available := make([]string, 0, len(raw.Contexts))
+			for name := range raw.Contexts {
+				available = append(available, name)
+			}
+			sort.Strings(available)
+			return nil, fmt.Errorf("context %q not found in kubeconfig, available contexts: %s", kubeContext, strings.Join(available, ", "))
+		}
+	}
+
+	config, err := clientConfig.ClientConfig()
+	if clientcmd.IsEmptyConfig(err) {
+		return nil, errNoKubeconfig
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A kubeconfig with two contexts pointing at different clusters
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com:6443
+- name: production
+  cluster:
+    server: https://production.example.com:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: admin
+- name: production
+  context:
+    cluster: production
+    user: admin
+current-context: staging
+`
+
+// Helper function to run outside a cluster with only the given kubeconfig, if any, to find
+func withoutCluster(t *testing.T, kubeconfig string) string {
+	t.Helper()
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if kubeconfig != "" {
+		if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("KUBECONFIG", path)
+	return path
+}
+
+func TestBuildRestConfigFallsBackToKubeconfig(t *testing.T) {
+	path := withoutCluster(t, testKubeconfig)
+
+	config, err := buildRestConfig(path, "")
+	if err != nil {
+		t.Fatalf("buildRestConfig() failed: %v", err)
+	}
+	if config.Host != "https://staging.example.com:6443" {
+		t.Errorf("host = %q, want the current context's cluster", config.Host)
+	}
+	// $KUBECONFIG is used when no path is given
+	if config, err := buildRestConfig("", ""); err != nil || config.Host != "https://staging.example.com:6443" {
+		t.Errorf("buildRestConfig() from $KUBECONFIG = %v, %v", config, err)
+	}
+}
+
+func TestBuildRestConfigNoKubeconfig(t *testing.T) {
+	withoutCluster(t, "")
+	if _, err := buildRestConfig("", ""); !errors.Is(err, errNoKubeconfig) {
+		t.Errorf("buildRestConfig() error = %v, want errNoKubeconfig", err)
+	}
+}
+
+func TestBuildRestConfigInClusterFailure(t *testing.T) {
+	// In a cluster, as far as the environment says, but without a service account token: the
+	// in-cluster config must fail loudly rather than fall back to a kubeconfig
+	withoutCluster(t, testKubeconfig)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	if _, err := os.Stat("/var/run/secrets/kubernetes.io/serviceaccount/token"); err == nil {
+		t.Skip("running with a real service account token")
+	}
+	if _, err := buildRestConfig("", ""); err == nil || errors.Is(err, errNoKubeconfig) {
+		t.Errorf("buildRestConfig() error = %v, want an in-cluster config error", err)
+	}
+}
+
+func TestBuildRestConfigContext(t *testing.T) {
+	path := withoutCluster(t, testKubeconfig)
+
+	config, err := buildRestConfig(path, "production")
+	if err != nil {
+		t.Fatalf("buildRestConfig() failed: %v", err)
+	}
+	if config.Host != "https://production.example.com:6443" {
+		t.Errorf("host = %q, want the production cluster", config.Host)
+	}
+
+	_, err = buildRestConfig(path, "qa")
+	if err == nil || !strings.Contains(err.Error(), `"qa"`) || !strings.Contains(err.Error(), "production, staging") {
+		t.Errorf("buildRestConfig() error = %v, want one naming qa and listing production, staging", err)
+	}
+}
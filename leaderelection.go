@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Timings of the leader election Lease
+type leaseTimings struct {
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// Lease timings used in production, client-go's usual defaults
+var defaultLeaseTimings = leaseTimings{leaseDuration: 15 * time.Second, renewDeadline: 10 * time.Second, retryPeriod: 2 * time.Second}
+
+// runLeaderElected blocks until stopCh closes or leadership is lost, running the controller's
+// workers only while this replica holds the Lease. The workers run under the leadership
+// context, so once leadership ends they start nothing new and in-flight API calls are
+// cancelled while the queues drain; use controller.Wait afterwards to bound the drain.
+func runLeaderElected(logger *slog.Logger, clientset kubernetes.Interface, namespace, name string, timings leaseTimings, controller *Controller, workers, podWorkers int, stopCh <-chan struct{}) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname: %w", err)
+	}
+	// The hostname is the pod name in a cluster; the suffix keeps identities unique outside one
+	identity := hostname + "_" + uuid.New().String()
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	logger = logger.With("lease", namespace+"/"+name, "identity", identity)
+	leaderelection.RunOrDie(wait.ContextForChannel(stopCh), leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: timings.leaseDuration,
+		RenewDeadline: timings.renewDeadline,
+		RetryPeriod:   timings.retryPeriod,
+		// Not released on shutdown: a worker may still be finishing an item, and another
+		// replica must not start rebooting nodes until the lease expires
+		ReleaseOnCancel: false,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				logger.Info("Started leading")
+				controller.Start(leaderCtx, workers, podWorkers, leaderCtx.Done())
+			},
+			OnStoppedLeading: func() {
+				logger.Info("Stopped leading")
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					logger.Info("Another replica is leading", "leader", leader)
+				}
+			},
+		},
+	})
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestWorkersStartOnlyWhenLeading(t *testing.T) {
+	keys := testKeys(t)
+	timings := leaseTimings{leaseDuration: time.Second, renewDeadline: 500 * time.Millisecond, retryPeriod: 50 * time.Millisecond}
+	// Another replica holds the lease, and never renews it
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "reboot-agent"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("other-replica"),
+			LeaseDurationSeconds: ptr.To(int32(1)),
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		},
+	}
+	c, client := newTestController(t, lease, testNode("node-1", map[string]string{keys.Reboot: ""}))
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := runLeaderElected(discardLogger(), client, "kube-system", "reboot-agent", timings, c, 1, 1, stopCh); err != nil {
+			t.Errorf("runLeaderElected() failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		close(stopCh)
+		<-done
+		c.Wait(5 * time.Second)
+	})
+
+	// The node is queued, but nothing may handle it while the other replica leads
+	time.Sleep(timings.leaseDuration / 2)
+	if inProgress := nodesInProgress(t, client, keys); len(inProgress) != 0 {
+		t.Fatalf("node rebooted while another replica held the lease: %v", inProgress)
+	}
+
+	// Once the lease expires this replica leads and its workers handle the node
+	waitForCachedNode(t, c, "node-1", func(node *v1.Node) bool { return rebootInProgress(node, keys) })
+	current, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(), "reboot-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := ptr.Deref(current.Spec.HolderIdentity, ""); holder == "other-replica" {
+		t.Errorf("node rebooted while the lease still names %s", holder)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// rebootLimiter is a counting semaphore bounding how many nodes reboot at once. A slot is held
+// per node from the moment it is marked reboot-in-progress until that annotation is cleared.
+// With a group label set, nodes are split into maintenance groups by its value, each with max
+// slots of its own, so groups reboot in parallel without blocking each other. Nodes without
+// the label form a default group. With a budget set, each slot is also held in the budget
+// shared with workload restarts.
+type rebootLimiter struct {
+	mu         sync.Mutex
+	max        int
+	groupLabel string
+	holders    map[string]string // Node name to its maintenance group
+	budget     *disruptionBudget
+}
+
+func newRebootLimiter(max int) *rebootLimiter {
+	return &rebootLimiter{max: max, holders: make(map[string]string)}
+}
+
+// group returns the node's maintenance group, empty for the default group
+func (l *rebootLimiter) group(node *v1.Node) string {
+	if l.groupLabel == "" {
+		return ""
+	}
+	return node.Labels[l.groupLabel]
+}
+
+// tryAcquire takes a slot for the node in its maintenance group if one is free. A node that
+// already holds a slot acquires it again without taking another.
+func (l *rebootLimiter) tryAcquire(nodeName, group string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, held := l.holders[nodeName]; held {
+		return true
+	}
+	inGroup := 0
+	for _, holderGroup := range l.holders {
+		if holderGroup == group {
+			inGroup++
+		}
+	}
+	if inGroup >= l.max || !l.budget.tryAcquire(nodeDisruption(nodeName)) {
+		return false
+	}
+	l.holders[nodeName] = group
+	return true
+}
+
+// release frees the node's slot, if it holds one
+func (l *rebootLimiter) release(nodeName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.holders, nodeName)
+	l.budget.release(nodeDisruption(nodeName))
+}
+
+// hold records that the node holds a slot in its maintenance group, even past the limit. A
+// node already rebooting keeps its slot whatever the limit, e.g. after a restart or failover
+// left the limiter empty.
+func (l *rebootLimiter) hold(nodeName, group string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holders[nodeName] = group
+	l.budget.hold(nodeDisruption(nodeName))
+}
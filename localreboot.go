@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Ways --mode=agent can reboot its own host
+const (
+	localRebootSyscall = "syscall"
+	localRebootSysrq   = "sysrq"
+)
+
+// Kernel interface used by the sysrq method; the agent's container needs it mounted writable
+const sysrqTriggerPath = "/proc/sysrq-trigger"
+
+// localRebooter reboots the host the agent runs on, when running as a DaemonSet. It refuses to
+// act on any other node.
+type localRebooter struct {
+	nodeName string
+	reboot   func() error
+	verify   func() error
+}
+
+func newLocalRebooter(nodeName, method string) (*localRebooter, error) {
+	r := &localRebooter{nodeName: nodeName}
+	switch method {
+	case localRebootSyscall:
+		r.reboot, r.verify = rebootSyscall, verifySyscall
+	case localRebootSysrq:
+		r.reboot, r.verify = rebootSysrq, verifySysrq
+	default:
+		return nil, fmt.Errorf("unknown local reboot method %q, expected %s or %s", method, localRebootSyscall, localRebootSysrq)
+	}
+	return r, nil
+}
+
+// Reboot restarts the host. On success it doesn't return, or not for long.
+func (r *localRebooter) Reboot(ctx context.Context, node *v1.Node) error {
+	if node.Name != r.nodeName {
+		return fmt.Errorf("refusing to reboot node %s from the agent on node %s", node.Name, r.nodeName)
+	}
+	return r.reboot()
+}
+
+// Verify checks the agent has what its reboot method needs, without rebooting
+func (r *localRebooter) Verify(ctx context.Context, node *v1.Node) error {
+	if node.Name != r.nodeName {
+		return fmt.Errorf("the agent on node %s can't reboot node %s", r.nodeName, node.Name)
+	}
+	return r.verify()
+}
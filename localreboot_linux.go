@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Helper function to reboot through the reboot(2) syscall, syncing the disks first. Needs
+// CAP_SYS_BOOT in the host's PID namespace.
+func rebootSyscall() error {
+	syscall.Sync()
+	if err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_RESTART); err != nil {
+		return fmt.Errorf("reboot syscall failed: %w", err)
+	}
+	return nil
+}
+
+// Helper function to reboot through the magic SysRq key, syncing the disks first. The "s" key
+// would only queue an emergency sync, which a "b" written right after can beat to the reboot.
+func rebootSysrq() error {
+	syscall.Sync()
+	if err := os.WriteFile(sysrqTriggerPath, []byte("b"), 0); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", sysrqTriggerPath, err)
+	}
+	return nil
+}
+
+// Bit of CAP_SYS_BOOT in the capability sets of /proc/self/status
+const capSysBoot = 22
+
+// Helper function to check the agent holds CAP_SYS_BOOT, which the reboot syscall needs
+func verifySyscall() error {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return fmt.Errorf("failed to read the agent's capabilities: %w", err)
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		value, found := strings.CutPrefix(line, "CapEff:")
+		if !found {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse the agent's capabilities %q: %w", value, err)
+		}
+		if caps&(1<<capSysBoot) == 0 {
+			return fmt.Errorf("the agent lacks CAP_SYS_BOOT, needed by the syscall reboot method")
+		}
+		return nil
+	}
+	return fmt.Errorf("no effective capabilities in /proc/self/status")
+}
+
+// Helper function to check the SysRq trigger can be opened for writing. Nothing is written, so
+// the host isn't rebooted.
+func verifySysrq() error {
+	file, err := os.OpenFile(sysrqTriggerPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", sysrqTriggerPath, err)
+	}
+	return file.Close()
+}
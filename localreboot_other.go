@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// The reboot(2) syscall and /proc/sysrq-trigger are Linux only
+func rebootSyscall() error {
+	return errors.New("the syscall local reboot method is only supported on Linux")
+}
+
+func rebootSysrq() error {
+	return errors.New("the sysrq local reboot method is only supported on Linux")
+}
+
+func verifySyscall() error {
+	return errors.New("the syscall local reboot method is only supported on Linux")
+}
+
+func verifySysrq() error {
+	return errors.New("the sysrq local reboot method is only supported on Linux")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalRebooterOnlyRebootsItsNode(t *testing.T) {
+	rebooter, err := newLocalRebooter("node-1", localRebootSysrq)
+	if err != nil {
+		t.Fatalf("newLocalRebooter() failed: %v", err)
+	}
+	rebooted := 0
+	rebooter.reboot = func() error {
+		rebooted++
+		return nil
+	}
+
+	if err := rebooter.Reboot(context.Background(), testNode("node-2", nil)); err == nil {
+		t.Error("Reboot() of another node succeeded")
+	}
+	if rebooted != 0 {
+		t.Fatal("agent rebooted its host for another node")
+	}
+	if err := rebooter.Reboot(context.Background(), testNode("node-1", nil)); err != nil {
+		t.Fatalf("Reboot() of its own node failed: %v", err)
+	}
+	if rebooted != 1 {
+		t.Errorf("host rebooted %d times, want once", rebooted)
+	}
+
+	if _, err := newLocalRebooter("node-1", "kexec"); err == nil {
+		t.Error("newLocalRebooter() accepted an unknown method")
+	}
+}
+
+func TestLocalRebooterVerify(t *testing.T) {
+	rebooter, err := newLocalRebooter("node-1", localRebootSysrq)
+	if err != nil {
+		t.Fatalf("newLocalRebooter() failed: %v", err)
+	}
+	verified := 0
+	rebooter.verify = func() error {
+		verified++
+		return nil
+	}
+
+	if err := rebooter.Verify(context.Background(), testNode("node-2", nil)); err == nil {
+		t.Error("Verify() of another node succeeded")
+	}
+	if err := rebooter.Verify(context.Background(), testNode("node-1", nil)); err != nil || verified != 1 {
+		t.Errorf("Verify() of its own node = %v after %d checks, want one passing check", err, verified)
+	}
+}
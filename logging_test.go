@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("newLogger() failed: %v", err)
+	}
+	logger.Info("dropped", "node", "node-1")
+	logger.Warn("kept", "node", "node-1")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("want a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "kept" || line["node"] != "node-1" {
+		t.Errorf("logged %v, want msg=kept node=node-1", line)
+	}
+
+	buf.Reset()
+	if logger, err = newLogger(&buf, "DEBUG", "text"); err != nil {
+		t.Fatalf("newLogger() failed: %v", err)
+	}
+	logger.Debug("pod added", "pod", "web-1")
+	if !strings.Contains(buf.String(), "pod=web-1") {
+		t.Errorf("debug line missing from text output: %q", buf.String())
+	}
+
+	for _, tt := range []struct{ level, format string }{{"verbose", "text"}, {"info", "xml"}} {
+		if _, err := newLogger(&buf, tt.level, tt.format); err == nil {
+			t.Errorf("newLogger(%q, %q) succeeded, want an error", tt.level, tt.format)
+		}
+	}
+}
@@ -2,114 +2,606 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"path/filepath"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
-	// "k8s.io/apimachinery/pkg/util/runtime"
-	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
 )
 
+// Pod template annotation set by `kubectl rollout restart`, reused to trigger restarts
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// Exit code used when the informer caches fail to sync within --cache-sync-timeout
+const exitCacheSyncTimeout = 3
+
+// Values of --mode. A controller handles every node, an agent runs as a DaemonSet and only
+// handles the node it runs on, named by the NODE_NAME environment variable.
 const (
-	RebootAnnotation           = "reboot-agent.v1.sdlt.local/reboot"
-	RebootNeededAnnotation     = "reboot-agent.v1.sdlt.local/reboot-needed"
-	RebootInProgressAnnotation = "reboot-agent.v1.sdlt.local/reboot-in-progress"
+	modeController = "controller"
+	modeAgent      = "agent"
 )
 
 func main() {
-	var kubeconfig string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = filepath.Join(home, ".kube", "config")
-	} else {
-		kubeconfig = ""
+	configPath := flag.String("config", "", "YAML file setting any of the other flags by name; flags given on the command line override it")
+	mode := flag.String("mode", modeController, "controller to handle every node, or agent to run as a DaemonSet handling and rebooting only its own node (named by $NODE_NAME)")
+	localRebootMethod := flag.String("local-reboot-method", localRebootSyscall, "How --mode=agent reboots its host: syscall (needs CAP_SYS_BOOT and hostPID) or sysrq (needs "+sysrqTriggerPath+" writable)")
+	kubeconfig := flag.String("kubeconfig", "", "Path to a kubeconfig, only used when not running in a cluster (defaults to $KUBECONFIG, then ~/.kube/config)")
+	kubeContext := flag.String("context", "", "Kubeconfig context to use instead of the current one (implies using the kubeconfig even in a cluster)")
+	cacheSyncTimeout := flag.Duration("cache-sync-timeout", 2*time.Minute, "Maximum time to wait for informer caches to sync at startup (0 waits forever)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time to wait for in-flight queue items to finish on SIGINT/SIGTERM")
+	namespace := flag.String("namespace", "", "Only watch pods in this namespace (empty watches all namespaces)")
+	nodeLabelSelector := flag.String("node-label-selector", "", "Only watch nodes matching this label selector, e.g. node-role=worker (empty watches all nodes)")
+	resyncPeriod := flag.Duration("resync-period", 10*time.Minute, "How often cached nodes in the reboot flow and annotated pods are reconciled again, retrying anything dropped or missed (0 disables)")
+	annotationPrefix := flag.String("annotation-prefix", defaultAnnotationPrefix, "Prefix of the annotation keys the agent reads and writes, to run several agents side by side")
+	annotationConfigMap := flag.String("annotation-configmap", "", "namespace/name of a ConfigMap whose reboot, reboot-needed and reboot-in-progress entries override those annotation keys, watched for changes (empty disables)")
+	workers := flag.Int("workers", 1, "Number of workers processing the node queue (and the RebootRequest queue when enabled)")
+	restartConcurrency := flag.Int("restart-concurrency", 4, "Number of workers processing pod reboot annotations, i.e. workload restarts running at once")
+	maxConcurrentReboots := flag.Int("max-concurrent-reboots", 1, "Maximum number of nodes rebooting at the same time, in each maintenance group with --maintenance-group-label (not supported with --mode=agent, where each agent only reboots its own node)")
+	apiTimeout := flag.Duration("api-timeout", 10*time.Second, "Maximum time a single API call may take before it is abandoned and retried")
+	conflictRetries := flag.Int("conflict-retries", retry.DefaultBackoff.Steps, "Number of attempts for a node annotation or Deployment update that hits a conflict")
+	conflictBackoff := flag.Duration("conflict-backoff", retry.DefaultBackoff.Duration, "Initial delay between conflicting node annotation or Deployment updates, growing exponentially")
+	requeueBaseDelay := flag.Duration("requeue-base-delay", 5*time.Millisecond, "Initial delay before retrying a node, pod or RebootRequest that failed, doubling on each failure")
+	requeueMaxDelay := flag.Duration("requeue-max-delay", 1000*time.Second, "Maximum delay between retries of a failing node, pod or RebootRequest")
+	requeueMaxRetries := flag.Int("requeue-max-retries", 0, "Consecutive failures after which a node, pod or RebootRequest is dropped until its next update, or resync while it is still in the reboot flow (0 retries forever)")
+	dryRun := flag.Bool("dry-run", false, "Log the changes the agent would make to nodes and workloads without making them")
+	rebootWindow := flag.String("reboot-window", "", "Only start reboots inside this window, as HH:MM-HH:MM[,days] e.g. 22:00-04:00,mon-fri (empty allows any time)")
+	rebootWindowTimezone := flag.String("reboot-window-timezone", "UTC", "IANA time zone --reboot-window is given in")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Minute, "Maximum time to wait for pods to be evicted from a node before rebooting it, unless the node's drain-timeout annotation overrides it")
+	drainForce := flag.Bool("drain-force", false, "Delete pods still on the node once --drain-timeout expires, bypassing PodDisruptionBudgets, instead of retrying the drain later")
+	maintenanceGroupLabel := flag.String("maintenance-group-label", "", "Node label whose value puts nodes in maintenance groups that reboot independently, --max-concurrent-reboots applying within each group; nodes without the label form a default group (empty puts every node in one group)")
+	maxTotalDisruptions := flag.Int("max-total-disruptions", 0, "Maximum number of node reboots and workload restarts in progress at the same time, counted together; a restart over the limit waits like a reboot (0 doesn't limit)")
+	maxConcurrentDrains := flag.Int("max-concurrent-drains", 0, "Maximum number of node drains running at the same time, apart from --max-concurrent-reboots; the drain timeout starts once a slot is free (0 doesn't limit)")
+	drainIncludeNamespaces := flag.String("drain-include-namespaces", "", "Comma-separated namespaces a drain evicts pods from (empty means all)")
+	drainExcludeNamespaces := flag.String("drain-exclude-namespaces", "", "Comma-separated namespaces a drain never evicts pods from, e.g. kube-system,monitoring; wins over --drain-include-namespaces")
+	failOnUndrainable := flag.Bool("fail-on-undrainable", false, "Abort the reboot, leaving the node cordoned, while it runs pods the drain namespace filters leave out, instead of rebooting with them")
+	waitForReschedule := flag.Bool("wait-for-reschedule", false, "After draining a node, wait for the evicted pods' controllers to have as many Ready pods on other nodes before rebooting")
+	rescheduleTimeout := flag.Duration("reschedule-timeout", 5*time.Minute, "Maximum time --wait-for-reschedule waits before rebooting anyway")
+	fastPathEmptyNodes := flag.Bool("fast-path-empty-nodes", false, "Skip the cordon and drain of a node that only runs pods a drain leaves in place (DaemonSet, mirror and finished pods)")
+	rebootTaint := flag.String("reboot-taint", "", "Taint to put on a node while it reboots, as key[=value]:effect e.g. reboot-agent/rebooting=true:NoExecute (empty disables)")
+	rebootStuckTimeout := flag.Duration("reboot-stuck-timeout", 30*time.Minute, "Report a node as stuck once it has been marked reboot-in-progress for this long")
+	restartCooldown := flag.Duration("restart-cooldown", 5*time.Minute, "Skip restarting a workload that was already restarted within this duration (0 disables)")
+	ownerMaxDepth := flag.Int("owner-max-depth", 5, "Maximum number of owner references followed up from a pod to find the workload to restart")
+	waitForRollout := flag.Bool("wait-for-rollout", false, "After restarting a Deployment, wait for its rollout to complete and log the outcome")
+	rolloutTimeout := flag.Duration("rollout-timeout", 5*time.Minute, "Maximum time --wait-for-rollout waits for a Deployment rollout")
+	metricsAddr := flag.String("metrics-addr", ":8080", "Address to serve Prometheus metrics and the /reboots state endpoint on (empty disables)")
+	enablePartialDrain := flag.Bool("enable-partial-drain", false, "Also serve POST /drain?node=<name>&selector=<labels> on --metrics-addr, evicting only the node's pods matching the selector without cordoning or rebooting it; the endpoint is unauthenticated, so only enable it where --metrics-addr is not exposed")
+	metricsSampleInterval := flag.Duration("metrics-sample-interval", 15*time.Second, "How often the informer_cached_objects gauges are sampled from the caches")
+	healthAddr := flag.String("health-addr", ":8081", "Address to serve the /healthz and /readyz probes on (empty disables)")
+	failOnMetricsBindError := flag.Bool("fail-on-metrics-bind-error", false, "Exit if the metrics or health address can't be bound, instead of running without that server")
+	watchErrorThreshold := flag.Int("watch-error-threshold", 5, "Fail /healthz and /readyz once an informer's watch has failed this many times within --watch-error-window (0 disables)")
+	watchErrorWindow := flag.Duration("watch-error-window", 5*time.Minute, "Period over which watch failures count towards --watch-error-threshold")
+	verifyBackendsOnly := flag.Bool("verify-backends", false, "Check the reboot backend can reach and command every watched node, without rebooting any, then exit (non-zero if any check fails)")
+	once := flag.Bool("once", false, "Handle every node and pod once against the current cluster state and exit, non-zero if any failed")
+	enableRebootRequests := flag.Bool("enable-reboot-requests", false, "Also reboot nodes listed in RebootRequest resources (requires the CRD in config/crd)")
+	enableLeaderElection := flag.Bool("enable-leader-election", false, "Only process nodes and pods while holding a Lease, so several replicas can run safely")
+	leaderElectionNamespace := flag.String("leader-election-namespace", "kube-system", "Namespace of the leader election Lease")
+	leaderElectionID := flag.String("leader-election-id", "reboot-agent", "Name of the leader election Lease")
+	notifyWebhookURL := flag.String("notify-webhook-url", "", "POST a JSON notification to this URL on reboot transitions (empty disables)")
+	notifyMinSeverity := flag.String("notify-min-severity", severityInfo, "Only notify for transitions at or above this severity: info or warning")
+	sshUser := flag.String("ssh-user", "root", "User to SSH to nodes as to run --reboot-command")
+	sshKeyPath := flag.String("ssh-key-path", "", "Private key to SSH to nodes with to reboot them (empty leaves the reboot to the node itself, which watches the reboot-in-progress annotation)")
+	sshKnownHosts := flag.String("ssh-known-hosts", "", "known_hosts file to verify node host keys against, required with --ssh-key-path")
+	sshPort := flag.Int("ssh-port", 22, "SSH port on the nodes")
+	rebootCommand := flag.String("reboot-command", "sudo systemctl reboot", "Command run over SSH to reboot a node")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	flag.Parse()
+
+	if *configPath != "" {
+		config, err := LoadConfig(*configPath)
+		if err == nil {
+			err = config.apply(flag.CommandLine)
+		}
+		if err == nil {
+			err = addRolloutRestarts(config.RolloutRestarts)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if errs := validation.IsDNS1123Label(*namespace); *namespace != "" && len(errs) > 0 {
+		logger.Error("Invalid --namespace", "namespace", *namespace, "error", strings.Join(errs, "; "))
+		os.Exit(2)
+	}
+	nodeSelector, err := labels.Parse(*nodeLabelSelector)
+	if err != nil {
+		logger.Error("Invalid --node-label-selector", "selector", *nodeLabelSelector, "error", err)
+		os.Exit(2)
+	}
+	keys, err := newAnnotationKeys(*annotationPrefix)
+	if err != nil {
+		logger.Error("Invalid --annotation-prefix", "error", err)
+		os.Exit(2)
+	}
+	var keysNamespace, keysName string
+	if *annotationConfigMap != "" {
+		keysNamespace, keysName, err = parseConfigMapRef(*annotationConfigMap)
+		if err != nil {
+			logger.Error("Invalid --annotation-configmap", "error", err)
+			os.Exit(2)
+		}
+	}
+	if *resyncPeriod < 0 {
+		logger.Error("--resync-period must not be negative", "resync-period", *resyncPeriod)
+		os.Exit(2)
+	}
+	if *maxConcurrentReboots < 1 {
+		logger.Error("--max-concurrent-reboots must be at least 1", "max-concurrent-reboots", *maxConcurrentReboots)
+		os.Exit(2)
+	}
+	if errs := validation.IsQualifiedName(*maintenanceGroupLabel); *maintenanceGroupLabel != "" && len(errs) > 0 {
+		logger.Error("Invalid --maintenance-group-label", "label", *maintenanceGroupLabel, "error", strings.Join(errs, "; "))
+		os.Exit(2)
+	}
+	if *maxTotalDisruptions < 0 {
+		logger.Error("--max-total-disruptions must not be negative", "max-total-disruptions", *maxTotalDisruptions)
+		os.Exit(2)
+	}
+	if *maxConcurrentDrains < 0 {
+		logger.Error("--max-concurrent-drains must not be negative", "max-concurrent-drains", *maxConcurrentDrains)
+		os.Exit(2)
+	}
+	if *enablePartialDrain && *metricsAddr == "" {
+		logger.Error("--enable-partial-drain serves /drain on --metrics-addr, which is empty")
+		os.Exit(2)
+	}
+	if *conflictRetries < 1 {
+		logger.Error("--conflict-retries must be at least 1", "conflict-retries", *conflictRetries)
+		os.Exit(2)
+	}
+	if _, ok := severityRank[*notifyMinSeverity]; !ok {
+		logger.Error("--notify-min-severity must be info or warning", "notify-min-severity", *notifyMinSeverity)
+		os.Exit(2)
+	}
+	if *requeueBaseDelay <= 0 || *requeueMaxDelay < *requeueBaseDelay {
+		logger.Error("--requeue-base-delay must be positive and no more than --requeue-max-delay", "requeue-base-delay", *requeueBaseDelay, "requeue-max-delay", *requeueMaxDelay)
+		os.Exit(2)
+	}
+	if *requeueMaxRetries < 0 {
+		logger.Error("--requeue-max-retries must not be negative", "requeue-max-retries", *requeueMaxRetries)
+		os.Exit(2)
+	}
+	if *watchErrorThreshold < 0 {
+		logger.Error("--watch-error-threshold must not be negative", "watch-error-threshold", *watchErrorThreshold)
+		os.Exit(2)
+	}
+	if *ownerMaxDepth < 1 {
+		logger.Error("--owner-max-depth must be at least 1", "owner-max-depth", *ownerMaxDepth)
+		os.Exit(2)
+	}
+	if *metricsSampleInterval <= 0 {
+		logger.Error("--metrics-sample-interval must be positive", "metrics-sample-interval", *metricsSampleInterval)
+		os.Exit(2)
+	}
+	if *workers < 1 {
+		logger.Error("--workers must be at least 1", "workers", *workers)
+		os.Exit(2)
+	}
+	if *restartConcurrency < 1 {
+		logger.Error("--restart-concurrency must be at least 1", "restart-concurrency", *restartConcurrency)
+		os.Exit(2)
+	}
+	if *once && *enableLeaderElection {
+		// A one-off run would go ahead without ever taking the Lease
+		logger.Error("--once can't be combined with --enable-leader-election")
+		os.Exit(2)
+	}
+
+	// In agent mode the node informer is scoped to our own node, and its pods
+	var agentNodeName string
+	switch *mode {
+	case modeController:
+	case modeAgent:
+		agentNodeName = os.Getenv("NODE_NAME")
+		if agentNodeName == "" {
+			logger.Error("--mode=agent needs the NODE_NAME environment variable, set it from spec.nodeName with the downward API")
+			os.Exit(2)
+		}
+		if *enableLeaderElection || *enableRebootRequests || *sshKeyPath != "" {
+			logger.Error("--mode=agent can't be combined with --enable-leader-election, --enable-reboot-requests or --ssh-key-path")
+			os.Exit(2)
+		}
+		// Agents don't share a limit, so one set here would silently allow that many reboots per
+		// node rather than across them
+		if flagSet(flag.CommandLine, "max-concurrent-reboots") || flagSet(flag.CommandLine, "max-total-disruptions") || *maintenanceGroupLabel != "" {
+			logger.Error("--mode=agent can't be combined with --max-concurrent-reboots, --max-total-disruptions or --maintenance-group-label, agents don't coordinate reboots across nodes")
+			os.Exit(2)
+		}
+	default:
+		logger.Error("--mode must be controller or agent", "mode", *mode)
+		os.Exit(2)
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	var rebooter Rebooter = noopRebooter{logger: logger}
+	if agentNodeName != "" {
+		rebooter, err = newLocalRebooter(agentNodeName, *localRebootMethod)
+		if err != nil {
+			logger.Error("Invalid --local-reboot-method", "error", err)
+			os.Exit(2)
+		}
+	}
+	if *sshKeyPath != "" {
+		if *sshKnownHosts == "" {
+			logger.Error("--ssh-known-hosts is required with --ssh-key-path")
+			os.Exit(2)
+		}
+		rebooter, err = NewSSHRebooter(*sshUser, *sshKeyPath, *sshKnownHosts, *sshPort, *rebootCommand, *apiTimeout)
+		if err != nil {
+			logger.Error("Failed to set up SSH reboots", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	namespaceFilter := drainFilter{failOnUndrainable: *failOnUndrainable}
+	if namespaceFilter.include, err = parseNamespaceSet(*drainIncludeNamespaces); err != nil {
+		logger.Error("Invalid --drain-include-namespaces", "error", err)
+		os.Exit(2)
+	}
+	if namespaceFilter.exclude, err = parseNamespaceSet(*drainExcludeNamespaces); err != nil {
+		logger.Error("Invalid --drain-exclude-namespaces", "error", err)
+		os.Exit(2)
+	}
+
+	var taint *v1.Taint
+	if *rebootTaint != "" {
+		if taint, err = parseTaint(*rebootTaint); err != nil {
+			logger.Error("Invalid --reboot-taint", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	var rolloutWait time.Duration
+	if *waitForRollout {
+		if *rolloutTimeout <= 0 {
+			logger.Error("--rollout-timeout must be positive", "rollout-timeout", *rolloutTimeout)
+			os.Exit(2)
+		}
+		rolloutWait = *rolloutTimeout
+	}
+
+	var rescheduleWait time.Duration
+	if *waitForReschedule {
+		if *rescheduleTimeout <= 0 {
+			logger.Error("--reschedule-timeout must be positive", "reschedule-timeout", *rescheduleTimeout)
+			os.Exit(2)
+		}
+		rescheduleWait = *rescheduleTimeout
+	}
+
+	var window *MaintenanceWindow
+	if *rebootWindow != "" {
+		loc, err := time.LoadLocation(*rebootWindowTimezone)
+		if err != nil {
+			logger.Error("Invalid --reboot-window-timezone", "timezone", *rebootWindowTimezone, "error", err)
+			os.Exit(2)
+		}
+		window, err = parseMaintenanceWindow(*rebootWindow, loc)
+		if err != nil {
+			logger.Error("Invalid --reboot-window", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	backoff := retry.DefaultBackoff
+	backoff.Steps = *conflictRetries
+	backoff.Duration = *conflictBackoff
+	requeueBackoff := wait.Backoff{Duration: *requeueBaseDelay, Cap: *requeueMaxDelay, Steps: *requeueMaxRetries}
+
+	config, err := buildRestConfig(*kubeconfig, *kubeContext)
 	if err != nil {
-		log.Fatalf("Failed to build config: %v", err)
+		logger.Error("Failed to build config", "error", err)
+		os.Exit(1)
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Fatalf("Failed to create clientset: %v", err)
+		logger.Error("Failed to create clientset", "error", err)
+		os.Exit(1)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		logger.Error("Failed to create dynamic client", "error", err)
+		os.Exit(1)
 	}
 
+	// The annotation ConfigMap overrides the keys from the flags, which stay the fallback should
+	// it be deleted later
+	flagKeys := keys
+	if *annotationConfigMap != "" {
+		keys, err = loadAnnotationConfigMap(context.Background(), clientset, keysNamespace, keysName, flagKeys, *apiTimeout)
+		if err != nil {
+			logger.Error("Failed to load the annotation ConfigMap", "configmap", *annotationConfigMap, "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Using annotation keys", "reboot", keys.Reboot, "reboot-needed", keys.RebootNeeded, "reboot-in-progress", keys.RebootInProgress)
+	}
+
+	recorder, broadcaster := newEventRecorder(logger, clientset, *dryRun)
+	defer broadcaster.Shutdown()
+	if *notifyWebhookURL != "" && !*dryRun {
+		recorder = &notifyingRecorder{
+			EventRecorder: recorder,
+			logger:        logger,
+			notifier:      newWebhookNotifier(*notifyWebhookURL, *apiTimeout),
+			minSeverity:   *notifyMinSeverity,
+			timeout:       2 * *apiTimeout,
+		}
+	}
+
+	// Close stopCh on SIGINT/SIGTERM so a `kubectl delete pod` shuts the agent down cleanly
 	stopCh := make(chan struct{})
-	defer close(stopCh)
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signalCh
+		logger.Info("Received signal, shutting down", "signal", sig.String())
+		close(stopCh)
+	}()
+
+	// Without leader election, workers use a context that outlives any single watch, so
+	// in-flight reboot handling survives the informers reconnecting to the apiserver. It is
+	// only cancelled once the shutdown grace period is over, letting workers finish after
+	// stopCh closes.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Create a shared informer factory and use it to create a node informer
-	factory := informers.NewSharedInformerFactory(clientset, time.Second*10)
-	nodeInformer := factory.Core().V1().Nodes().Informer()
-	podInformer := factory.Core().V1().Pods().Informer()
-
-	// Define event handlers for pod informer
-	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			pod := obj.(*v1.Pod)
-			fmt.Printf("Pod added: %s\n", pod.Name)
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			oldPod := oldObj.(*v1.Pod)
-			newPod := newObj.(*v1.Pod)
-			fmt.Printf("Pod updated: %s\n", newPod.Name)
-
-			// Check for changes in annotations
-			if !equalAnnotations(oldPod.Annotations, newPod.Annotations) {
-				fmt.Printf("Annotations updated on pod %s: %v\n", newPod.Name, newPod.Annotations)
-
-				// Handle specific annotations
-				handlePodAnnotations(newPod, clientset)
-			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			pod := obj.(*v1.Pod)
-			fmt.Printf("Pod deleted: %s\n", pod.Name)
-		},
+	factory := newInformerFactory(clientset, *resyncPeriod, *namespace)
+	nodeInformer := newNodeInformer(factory, nodeSelector, agentNodeName)
+	podInformer := newPodInformer(factory, *namespace, agentNodeName)
+
+	// Log and count watch drops so reconnects are visible; the informers re-establish the watch
+	// themselves, but too many failures in a row fail the health probes
+	watches := newWatchHealth(*watchErrorThreshold, *watchErrorWindow)
+	for name, informer := range map[string]cache.SharedIndexInformer{"nodes": nodeInformer, "pods": podInformer} {
+		if err := informer.SetWatchErrorHandler(watches.handler(logger, name)); err != nil {
+			logger.Error("Failed to set watch error handler", "informer", name, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	decisions := newDecisionLog()
+	disruptions := newDisruptionBudget(*maxTotalDisruptions)
+	limiter := newRebootLimiter(*maxConcurrentReboots)
+	limiter.budget = disruptions
+	limiter.groupLabel = *maintenanceGroupLabel
+	controller, err := NewController(logger, clientset, dynamicClient, recorder, nodeInformer, podInformer, controllerConfig{
+		keys:            keys,
+		rebootLimiter:   limiter,
+		decisions:       decisions,
+		rebootWindow:    window,
+		drainTimeout:    *drainTimeout,
+		drainForce:      *drainForce,
+		drainFilter:     namespaceFilter,
+		drainLimiter:    newDrainLimiter(*maxConcurrentDrains),
+		evictions:       newEvictionAPI(clientset.Discovery()),
+		rescheduleWait:  rescheduleWait,
+		fastPathEmpty:   *fastPathEmptyNodes,
+		rebootTaint:     taint,
+		stuckTimeout:    *rebootStuckTimeout,
+		rebooter:        rebooter,
+		restartCooldown: newRestartCooldown(*restartCooldown),
+		disruptions:     disruptions,
+		ownerMaxDepth:   *ownerMaxDepth,
+		rolloutTimeout:  rolloutWait,
+		apiTimeout:      *apiTimeout,
+		conflictBackoff: backoff,
+		requeueBackoff:  requeueBackoff,
+		dryRun:          *dryRun,
 	})
+	if err != nil {
+		logger.Error("Failed to create controller", "error", err)
+		os.Exit(1)
+	}
+
+	// RebootRequests come from a CRD, so they're watched through the dynamic client
+	syncedInformers := []namedInformer{
+		{name: "nodes", synced: nodeInformer.HasSynced},
+		{name: "pods", synced: podInformer.HasSynced},
+	}
+	cachedStores := map[string]cache.Store{"Node": nodeInformer.GetStore(), "Pod": podInformer.GetStore()}
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, *resyncPeriod)
+	if *enableRebootRequests {
+		rebootRequestInformer := dynamicFactory.ForResource(rebootRequestResource).Informer()
+		if err := rebootRequestInformer.SetWatchErrorHandler(watches.handler(logger, "rebootrequests")); err != nil {
+			logger.Error("Failed to set watch error handler", "informer", "rebootrequests", "error", err)
+			os.Exit(1)
+		}
+		if err := controller.EnableRebootRequests(rebootRequestInformer); err != nil {
+			logger.Error("Failed to enable reboot requests", "error", err)
+			os.Exit(1)
+		}
+		syncedInformers = append(syncedInformers, namedInformer{name: "rebootrequests", synced: rebootRequestInformer.HasSynced})
+		cachedStores["RebootRequest"] = rebootRequestInformer.GetStore()
+	}
+
+	var keysFactory informers.SharedInformerFactory
+	if *annotationConfigMap != "" {
+		var keysInformer cache.SharedIndexInformer
+		keysFactory, keysInformer = newAnnotationConfigMapInformer(clientset, keysNamespace, keysName, *resyncPeriod)
+		if err := keysInformer.SetWatchErrorHandler(watches.handler(logger, "configmaps")); err != nil {
+			logger.Error("Failed to set watch error handler", "informer", "configmaps", "error", err)
+			os.Exit(1)
+		}
+		if err := controller.WatchAnnotationConfigMap(keysInformer, flagKeys); err != nil {
+			logger.Error("Failed to watch the annotation ConfigMap", "error", err)
+			os.Exit(1)
+		}
+		syncedInformers = append(syncedInformers, namedInformer{name: "configmaps", synced: keysInformer.HasSynced})
+	}
 
-	// Define event handlers for node informer
-	// nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-	// 	AddFunc: func(obj interface{}) {
-	// 		node := obj.(*v1.Node)
-	// 		fmt.Printf("Node added: %s\n", node.Name)
-	// 	},
-	// 	UpdateFunc: func(oldObj, newObj interface{}) {
-	// 		oldNode := oldObj.(*v1.Node)
-	// 		newNode := newObj.(*v1.Node)
-	// 		fmt.Printf("Node updated: %s\n", newNode.Name)
-
-	// 		// Check for changes in annotations
-	// 		if !equalAnnotations(oldNode.Annotations, newNode.Annotations) {
-	// 			fmt.Printf("Annotations updated on node %s: %v\n", newNode.Name, newNode.Annotations)
-
-	// 			// Handle specific annotations
-	// 			handleNodeAnnotations(clientset, newNode)
-	// 		}
-	// 	},
-	// 	DeleteFunc: func(obj interface{}) {
-	// 		node := obj.(*v1.Node)
-	// 		fmt.Printf("Node deleted: %s\n", node.Name)
-	// 	},
-	// })
+	// Ready once the caches have synced
+	var ready atomic.Bool
+	if *healthAddr != "" {
+		if err := serveHealth(logger, *healthAddr, &ready, watches, stopCh); err != nil {
+			exitOnBindError(logger, *failOnMetricsBindError, err)
+		}
+	}
+	if *metricsAddr != "" {
+		registerInProgressGauges(metricsRegistry, controller.nodeLister, keys, *rebootStuckTimeout)
+		var drain http.Handler
+		if *enablePartialDrain {
+			drain = controller.partialDrainHandler(*drainTimeout)
+		}
+		if err := serveMetrics(logger, *metricsAddr, rebootsHandler(controller.nodeLister, controller.annotationKeys, *rebootStuckTimeout, decisions), drain, stopCh); err != nil {
+			exitOnBindError(logger, *failOnMetricsBindError, err)
+		}
+		sampleCacheSizes(cachedStores, *metricsSampleInterval, stopCh)
+	}
 
 	// Start the informer
 	factory.Start(stopCh)
+	dynamicFactory.Start(stopCh)
+	if keysFactory != nil {
+		keysFactory.Start(stopCh)
+	}
 
 	// Wait for all caches to sync
-	if ok := cache.WaitForCacheSync(stopCh, nodeInformer.HasSynced); !ok {
-		log.Fatalf("Failed to wait for caches to sync")
+	syncStart := time.Now()
+	unsynced := waitForCacheSync(stopCh, *cacheSyncTimeout, syncedInformers)
+	if len(unsynced) > 0 {
+		logger.Error("Timed out waiting for informer caches to sync", "timeout", *cacheSyncTimeout, "unsynced", strings.Join(unsynced, ", "))
+		os.Exit(exitCacheSyncTimeout)
+	}
+	cacheSyncDurationSeconds.Observe(time.Since(syncStart).Seconds())
+	ready.Store(true)
+
+	if *verifyBackendsOnly {
+		nodes, err := controller.nodeLister.List(labels.Everything())
+		if err != nil {
+			logger.Error("Failed to list nodes", "error", err)
+			os.Exit(1)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+		if failed := verifyBackends(ctx, logger, rebooter, nodes, *apiTimeout); failed > 0 {
+			logger.Error("Reboot backend verification failed", "failed", failed, "nodes", len(nodes))
+			os.Exit(1)
+		}
+		logger.Info("Reboot backend verification passed", "nodes", len(nodes))
+		return
+	}
+
+	if *once {
+		if err := controller.RunOnce(ctx); err != nil {
+			logger.Error("Reconciliation failed", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Reconciliation complete")
+		return
+	}
+
+	// Run until signalled (or, with leader election, until leadership is lost), then give
+	// in-flight items a grace period to finish
+	if *enableLeaderElection {
+		err := runLeaderElected(logger, clientset, *leaderElectionNamespace, *leaderElectionID, defaultLeaseTimings, controller, *workers, *restartConcurrency, stopCh)
+		if err != nil {
+			logger.Error("Failed to run leader election", "error", err)
+			os.Exit(1)
+		}
+	} else {
+		controller.Start(ctx, *workers, *restartConcurrency, stopCh)
+		<-stopCh
+	}
+	if !controller.Wait(*shutdownTimeout) {
+		logger.Warn("Timed out waiting for in-flight items, exiting", "timeout", *shutdownTimeout)
+	}
+}
+
+// Helper function to handle a metrics or health server that couldn't bind its address: exit if
+// fatal is set, otherwise carry on without that server
+func exitOnBindError(logger *slog.Logger, fatal bool, err error) {
+	if fatal {
+		logger.Error("Failed to start HTTP server", "error", err)
+		os.Exit(1)
+	}
+	logger.Error("Failed to start HTTP server, running without it", "error", err)
+}
+
+// Helper function to wait on a WaitGroup for at most timeout. Reports whether it finished.
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// An informer's HasSynced func paired with a name for diagnostics
+type namedInformer struct {
+	name   string
+	synced cache.InformerSynced
+}
+
+// Helper function to wait for informer caches to sync, giving up after timeout (0 waits until
+// stopCh closes). Returns the names of the informers that had not synced.
+func waitForCacheSync(stopCh <-chan struct{}, timeout time.Duration, informers []namedInformer) []string {
+	ctx := wait.ContextForChannel(stopCh)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	synced := make([]cache.InformerSynced, 0, len(informers))
+	for _, informer := range informers {
+		synced = append(synced, informer.synced)
+	}
+	if cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return nil
 	}
 
-	// Run forever
-	<-stopCh
+	var unsynced []string
+	for _, informer := range informers {
+		if !informer.synced() {
+			unsynced = append(unsynced, informer.name)
+		}
+	}
+	return unsynced
 }
 
 // Helper function to compare annotations
@@ -125,123 +617,794 @@ func equalAnnotations(a, b map[string]string) bool {
 	return true
 }
 
+// Helper function to check whether any of the annotations the pod handler acts on changed
+func rebootAnnotationsChanged(oldAnnotations, newAnnotations map[string]string, keys AnnotationKeys) bool {
+	for _, key := range []string{keys.Reboot, keys.RebootNeeded, keys.RebootInProgress} {
+		oldValue, oldExists := oldAnnotations[key]
+		newValue, newExists := newAnnotations[key]
+		if oldExists != newExists || oldValue != newValue {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to tell a real update from a resync, which replays the same object
+func resourceVersionChanged(oldObj, newObj metav1.Object) bool {
+	return oldObj.GetResourceVersion() != newObj.GetResourceVersion()
+}
+
+// Helper function to detect updates that only touched status: annotations and spec are unchanged
+func statusOnlyChange(oldAnnotations, newAnnotations map[string]string, oldSpec, newSpec interface{}) bool {
+	return equalAnnotations(oldAnnotations, newAnnotations) && equality.Semantic.DeepEqual(oldSpec, newSpec)
+}
+
+// Helper function to build the shared informer factory, watching only namespace when set.
+// Scoping the factory only affects the pod informer since nodes are cluster-scoped.
+func newInformerFactory(client kubernetes.Interface, resync time.Duration, namespace string) informers.SharedInformerFactory {
+	var options []informers.SharedInformerOption
+	if namespace != "" {
+		options = append(options, informers.WithNamespace(namespace))
+	}
+	return informers.NewSharedInformerFactoryWithOptions(client, resync, options...)
+}
+
+// Helper function to register the node informer on the factory. The label selector only applies
+// to nodes, so the node informer gets its own list options instead of tweaking the whole
+// factory. In agent mode (nodeName set) it only watches that node.
+func newNodeInformer(factory informers.SharedInformerFactory, selector labels.Selector, nodeName string) cache.SharedIndexInformer {
+	return factory.InformerFor(&v1.Node{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredNodeInformer(client, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, func(options *metav1.ListOptions) {
+			options.LabelSelector = selector.String()
+			if nodeName != "" {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", nodeName).String()
+			}
+		})
+	})
+}
+
+// Helper function to check whether a flag was set, on the command line or by the config file
+func flagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Helper function to register the pod informer on the factory, in namespace if set. In agent
+// mode (nodeName set) it only watches the pods bound to that node. The factory keeps one
+// informer per type, so the pod informer is either filtered or not.
+func newPodInformer(factory informers.SharedInformerFactory, namespace, nodeName string) cache.SharedIndexInformer {
+	if nodeName == "" {
+		return factory.Core().V1().Pods().Informer()
+	}
+	return factory.InformerFor(&v1.Pod{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredPodInformer(client, namespace, resync, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		})
+	})
+}
+
 // Handle specific annotations
-func handleNodeAnnotations(client *kubernetes.Clientset, node *v1.Node) {
+func (c *Controller) handleNodeAnnotations(ctx context.Context, node *v1.Node) (err error) {
+	keys := c.annotationKeys()
+	// The reason is on every log line and Event of the reboot cycle
+	reason := rebootReason(node, keys)
+	logger := c.logger.With("node", node.Name, "reboot_reason", reason.Code)
+	if reason.Detail != "" {
+		logger = logger.With("reboot_reason_detail", reason.Detail)
+	}
+	if c.rebootLimiter.groupLabel != "" {
+		logger = logger.With("maintenance_group", c.rebootLimiter.group(node))
+	}
+
+	now := time.Now()
+	decision := shouldReboot(logger, node, keys, c.rebootWindow, now, c.rebootLimiter)
+	// Someone else cordoned the node and is evicting its pods, most likely kubectl drain. The
+	// reboot waits for that to settle rather than draining alongside it, freeing its slot.
+	if decision.reboot {
+		draining, err := nodeManuallyDraining(ctx, c.clientset, node, keys, c.apiTimeout)
+		if err != nil {
+			logger.Warn("Failed to check for a manual drain, going ahead with the reboot", "error", err)
+		}
+		if draining {
+			c.rebootLimiter.release(node.Name)
+			decision = rebootDecision{requeue: true, reason: reasonManualDrain}
+			c.recorder.Eventf(node, v1.EventTypeNormal, eventManualDrainDetected, "Node is cordoned and being drained outside the agent, deferring the reboot until the drain settles")
+		}
+	}
+	c.decisions.record(node.Name, decision.reason, now)
+
+	// So is the reboot ID, from the moment the reboot is decided until the node is uncordoned
+	// after it. A reboot in progress or being cleaned up after carries its ID in an annotation.
+	rebootID := node.Annotations[keys.RebootID]
+	if decision.reboot {
+		rebootID = uuid.New().String()
+	}
+	if rebootID != "" {
+		logger = logger.With("reboot_id", rebootID)
+	}
+	recorder := rebootRecorder{EventRecorder: c.recorder, rebootID: rebootID, rebootReason: reason}
+	defer func() {
+		var rebootErr *RebootError
+		if errors.As(err, &rebootErr) {
+			c.recordNodeFailure(node.Name, rebootErr.Error())
+			c.results.send(RebootResult{Node: node.Name, RebootID: rebootID, Outcome: RebootOutcomeFailed, Phase: rebootErr.Phase, Err: rebootErr.Err})
+		}
+	}()
+
+	logger.Info("Reboot decision", "reboot", decision.reboot, "reason", decision.reason)
+	if decision.requeue {
+		return &requeueError{reason: decision.reason}
+	}
+	if decision.reboot {
+		rebootRequestsTotal.WithLabelValues(reason.Code).Inc()
+		payload := rebootPayload(logger, node.Annotations, keys)
+		logger.Info("Reboot requested", "priority", payload.Priority)
+		recorder.Eventf(node, v1.EventTypeNormal, eventRebootRequested, "Reboot requested by the %s annotation", keys.Reboot)
+
+		// A node with nothing to drain can go straight to the reboot, there is nothing for a
+		// cordon to protect. A pod scheduled in the meantime goes down with the node.
+		emptyNode := false
+		if c.fastPathEmpty {
+			var err error
+			if emptyNode, err = nodeDrainEmpty(ctx, c.clientset, node.Name, c.apiTimeout); err != nil {
+				logger.Warn("Failed to check for pods to drain, cordoning and draining", "error", err)
+			}
+		}
+		if emptyNode {
+			logger.Info("No pods to drain, taking the fast path without cordoning or draining")
+		} else {
+			// Keep new pods off the node before it goes down
+			if err := cordonNode(ctx, logger, c.clientset, node, keys, c.apiTimeout, c.dryRun); err != nil {
+				c.rebootLimiter.release(node.Name)
+				recorder.Eventf(node, v1.EventTypeWarning, eventRebootFailed, "Failed to cordon node: %v", err)
+				return &RebootError{Node: node.Name, Phase: phaseCordon, Err: err}
+			}
+
+			// Note what the drain evicts, to wait for it to come back up elsewhere
+			var owners map[drainedOwner]int
+			if c.rescheduleWait > 0 && !c.dryRun {
+				var err error
+				if owners, err = drainedOwners(ctx, c.clientset, node.Name, c.drainFilter, c.apiTimeout); err != nil {
+					logger.Warn("Failed to list the pods to drain, not waiting for them to be rescheduled", "error", err)
+				}
+			}
+
+			// Evict workloads. A failed eviction, or one still blocked by a PodDisruptionBudget when
+			// the timeout runs out, aborts the reboot and leaves the node cordoned for the requeue to
+			// retry - unless forcing, which deletes whatever is left once the timeout runs out.
+			// The timeout only starts once a drain slot is free, so waiting for one can't run it out.
+			release, err := c.drainLimiter.acquire(ctx)
+			if err != nil {
+				c.rebootLimiter.release(node.Name)
+				return &RebootError{Node: node.Name, Phase: phaseDrain, Err: fmt.Errorf("waiting for a drain slot: %w", err)}
+			}
+			drainStart := time.Now()
+			timeout := nodeDrainTimeout(logger, node, keys, c.drainTimeout)
+			drainCtx, cancel := context.WithTimeout(ctx, timeout)
+			err = drainNode(drainCtx, logger, c.clientset, c.evictions, node.Name, c.drainFilter, c.apiTimeout, c.dryRun)
+			cancel()
+			if errors.Is(err, errEvictionsTimedOut) && c.drainForce {
+				logger.Warn("Drain timed out, force deleting remaining pods", "timeout", timeout)
+				err = forceDeletePods(ctx, logger, c.clientset, node.Name, c.drainFilter, c.apiTimeout, c.dryRun)
+			}
+			release()
+			if err != nil {
+				c.rebootLimiter.release(node.Name)
+				recorder.Eventf(node, v1.EventTypeWarning, eventRebootFailed, "Failed to drain node: %v", err)
+				return &RebootError{Node: node.Name, Phase: phaseDrain, Err: err}
+			}
+			logger.Info("Node drained")
+			c.results.drained(node.Name, time.Since(drainStart))
+
+			// Rebooting before the evicted pods are back up elsewhere would leave their workloads
+			// short for longer. Past the timeout the reboot goes ahead all the same.
+			if len(owners) > 0 {
+				logger.Info("Waiting for evicted pods to be rescheduled", "timeout", c.rescheduleWait)
+				rescheduleCtx, cancel := context.WithTimeout(ctx, c.rescheduleWait)
+				err := waitForReschedule(rescheduleCtx, logger, c.clientset, node.Name, owners, c.apiTimeout)
+				cancel()
+				if err != nil && ctx.Err() != nil {
+					c.rebootLimiter.release(node.Name)
+					return &RebootError{Node: node.Name, Phase: phaseDrain, Err: err}
+				}
+				if err != nil {
+					logger.Warn("Evicted pods not rescheduled in time, rebooting anyway", "error", err)
+					recorder.Eventf(node, v1.EventTypeWarning, eventRescheduleTimedOut, "Evicted pods not Ready elsewhere within %s, rebooting anyway", c.rescheduleWait)
+				} else {
+					logger.Info("Evicted pods rescheduled")
+				}
+			}
+		}
 
-	if shouldReboot(node) {
 		// Set "reboot in progress" and clear reboot needed / reboot
-		node.Annotations[RebootInProgressAnnotation] = ""
-		delete(node.Annotations, RebootNeededAnnotation)
-		delete(node.Annotations, RebootAnnotation)
+		annotations := map[string]*string{
+			// The start time and boot ID let the agent tell when the node has actually rebooted
+			keys.RebootInProgress: ptr.To(time.Now().UTC().Format(time.RFC3339)),
+			keys.BootID:           ptr.To(node.Status.NodeInfo.BootID),
+			keys.RebootID:         ptr.To(rebootID),
+			keys.RebootNeeded:     nil,
+			keys.Reboot:           nil,
+		}
+		// Keep the reason for the rest of the cycle, a payload goes with the reboot annotation
+		annotations[keys.RebootReason] = ptr.To(reason.Code)
+		annotations[keys.RebootReasonDetail] = nil
+		if reason.Detail != "" {
+			annotations[keys.RebootReasonDetail] = ptr.To(reason.Detail)
+		}
+		err := patchNodeAnnotations(ctx, logger, c.clientset, node.Name, c.apiTimeout, c.conflictBackoff, c.dryRun, annotations)
+		if err != nil {
+			// If we cannot update the state - do not reboot
+			c.rebootLimiter.release(node.Name)
+			recorder.Eventf(node, v1.EventTypeWarning, eventRebootFailed, "Failed to set the %s annotation: %v", keys.RebootInProgress, err)
+			return &RebootError{Node: node.Name, Phase: phaseStart, Err: fmt.Errorf("failed to set %s annotation: %w", keys.RebootInProgress, err)}
+		}
+		if c.dryRun {
+			// Nothing was marked in progress, so nothing would ever release the slot
+			c.rebootLimiter.release(node.Name)
+			logger.Info("Dry run: reboot not started")
+			return nil
+		}
+		// Let pods that tolerate the taint react to the reboot
+		if c.rebootTaint != nil {
+			if err := applyRebootTaint(ctx, logger, c.clientset, node.Name, c.rebootTaint, c.apiTimeout, c.conflictBackoff, c.dryRun); err != nil {
+				// Not worth abandoning the reboot over, the node is already drained
+				logger.Warn("Failed to apply reboot taint", "taint", c.rebootTaint.ToString(), "error", err)
+			}
+		}
+		if err := c.rebooter.Reboot(ctx, node); err != nil {
+			// The node never went down - put the reboot annotation back so the requeue retries,
+			// leaving the node cordoned and drained
+			recorder.Eventf(node, v1.EventTypeWarning, eventRebootFailed, "Failed to reboot node: %v", err)
+			rollbackErr := patchNodeAnnotations(ctx, logger, c.clientset, node.Name, c.apiTimeout, c.conflictBackoff, c.dryRun, map[string]*string{
+				keys.RebootInProgress: nil,
+				keys.BootID:           nil,
+				keys.RebootID:         nil,
+				keys.Reboot:           ptr.To(""),
+			})
+			if rollbackErr != nil {
+				// Left in progress, the node is reported stuck once --reboot-stuck-timeout passes
+				logger.Error("Failed to roll back the reboot annotations", "error", rollbackErr)
+			} else {
+				c.rebootLimiter.release(node.Name)
+			}
+			return &RebootError{Node: node.Name, Phase: phaseReboot, Err: err}
+		}
+		logger.Info("Reboot started")
+		recorder.Eventf(node, v1.EventTypeNormal, eventRebootInProgress, "Reboot started, set the %s annotation", keys.RebootInProgress)
+		return nil
+	}
+
+	// Reboot complete - clear the rebootInProgress annotation once the node shows it has restarted
+	rebootIDCleared := false
+	if rebootInProgress(node, keys) {
+		// A reboot in progress overrides reboot and reboot-needed, drop them so the node's
+		// state isn't ambiguous
+		if contradictory := contradictoryAnnotations(node, keys); len(contradictory) > 0 {
+			logger.Warn("Clearing annotations contradicting the reboot in progress", "annotations", contradictory)
+			removals := make(map[string]*string, len(contradictory))
+			for _, key := range contradictory {
+				removals[key] = nil
+			}
+			if err := patchNodeAnnotations(ctx, logger, c.clientset, node.Name, c.apiTimeout, c.conflictBackoff, c.dryRun, removals); err != nil {
+				return fmt.Errorf("failed to clear contradictory annotations: %w", err)
+			}
+		}
 
-		// Update the node object
-		_, err := client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		if !rebootFinished(node, keys) {
+			if rebootStuck(node, keys, c.stuckTimeout, time.Now()) {
+				logger.Warn("Node has not come back from reboot", "timeout", c.stuckTimeout, "started_at", node.Annotations[keys.RebootInProgress])
+			} else {
+				logger.Debug("Waiting for node to come back from reboot")
+			}
+			return nil
+		}
+		logger.Info("Clearing in-progress reboot annotation", "annotation", keys.RebootInProgress)
+		completion := map[string]*string{
+			keys.RebootInProgress:   nil,
+			keys.BootID:             nil,
+			keys.RebootReason:       nil,
+			keys.RebootReasonDetail: nil,
+			keys.LastReboot:         ptr.To(time.Now().UTC().Format(time.RFC3339)),
+			keys.LegacyLastReboot:   nil,
+			keys.RebootCount:        ptr.To(strconv.Itoa(rebootCount(node, keys) + 1)),
+		}
+		// With a cordon or taint left to undo, the ID stays until that is done
+		if !cordonedByAgent(node, keys) && (c.rebootTaint == nil || !hasTaint(node, c.rebootTaint)) {
+			completion[keys.RebootID] = nil
+			rebootIDCleared = true
+		}
+		err := patchNodeAnnotations(ctx, logger, c.clientset, node.Name, c.apiTimeout, c.conflictBackoff, c.dryRun, completion)
 		if err != nil {
-			glog.Errorf("Failed to set %s annotation: %v", RebootInProgressAnnotation, err)
-			return // If we cannot update the state - do not reboot
+			return &RebootError{Node: node.Name, Phase: phaseComplete, Err: fmt.Errorf("failed to remove %s annotation: %w", keys.RebootInProgress, err)}
+		}
+		c.rebootLimiter.release(node.Name)
+		// Nothing was cleared in dry-run mode, the same reboot is seen completing on every pass
+		if !c.dryRun {
+			var took time.Duration
+			rebootsCompletedTotal.WithLabelValues(reason.Code).Inc()
+			if startedAt, err := time.Parse(time.RFC3339, node.Annotations[keys.RebootInProgress]); err == nil {
+				took = time.Since(startedAt)
+				rebootDurationSeconds.Observe(took.Seconds())
+			}
+			c.results.send(RebootResult{Node: node.Name, RebootID: rebootID, Outcome: RebootOutcomeCompleted, RebootDuration: took})
 		}
+		logger.Info("Reboot completed")
+		recorder.Eventf(node, v1.EventTypeNormal, eventRebootCompleted, "Reboot completed, cleared the %s annotation", keys.RebootInProgress)
 	}
 
-	// Reboot complete - clear the rebootInProgress annotation
-	// This is a niave assumption: the call to reboot is blocking - if we've reached this, assume the node has restarted.
-	if rebootInProgress(node) {
-		glog.Info("Clearing in-progress reboot annotation")
-		delete(node.Annotations, RebootInProgressAnnotation)
-		_, err := client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+	// No reboot in progress any more - remove the reboot taint and undo our cordon. This runs on every pass rather than only
+	// right after clearing the annotation, so a failed uncordon is retried.
+	if c.rebootTaint != nil && hasTaint(node, c.rebootTaint) {
+		if err := removeRebootTaint(ctx, logger, c.clientset, node.Name, c.rebootTaint, c.apiTimeout, c.conflictBackoff, c.dryRun); err != nil {
+			return &RebootError{Node: node.Name, Phase: phaseUncordon, Err: fmt.Errorf("failed to remove reboot taint: %w", err)}
+		}
+		logger.Info("Reboot taint removed", "taint", c.rebootTaint.ToString())
+	}
+	if cordonedByAgent(node, keys) {
+		if err := uncordonNode(ctx, logger, c.clientset, node, keys, c.apiTimeout, c.dryRun); err != nil {
+			return &RebootError{Node: node.Name, Phase: phaseUncordon, Err: err}
+		}
+		logger.Info("Node uncordoned")
+	}
+	if _, exists := node.Annotations[keys.RebootID]; exists && !rebootIDCleared {
+		err := patchNodeAnnotations(ctx, logger, c.clientset, node.Name, c.apiTimeout, c.conflictBackoff, c.dryRun, map[string]*string{keys.RebootID: nil})
 		if err != nil {
-			glog.Errorf("Failed to remove %s annotation: %v", RebootInProgressAnnotation, err)
-			return
+			return &RebootError{Node: node.Name, Phase: phaseUncordon, Err: fmt.Errorf("failed to remove %s annotation: %w", keys.RebootID, err)}
 		}
 	}
 
-	// annotations := node.Annotations
-	// if annotations == nil {
-	// 	return
-	// }
+	return nil
+}
+
+// Helper function to patch only the given annotation keys on a node, leaving concurrent
+// writes to other keys intact. A nil value removes the key. All the keys change in one JSON
+// Patch, so a transition is applied entirely or not at all.
+//
+// Removing a key that isn't there fails a JSON Patch, so the patch is built against the node
+// as read from the apiserver rather than the informer cache, which is routinely stale. The
+// patch starts by testing the node's resourceVersion, so if the node changes in between, the
+// patch is rejected and rebuilt with backoff.
+func patchNodeAnnotations(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, nodeName string, apiTimeout time.Duration, backoff wait.Backoff, dryRun bool, annotations map[string]*string) error {
+	return retry.OnError(backoff, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsInvalid(err)
+	}, func() error {
+		getCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+		node, err := client.CoreV1().Nodes().Get(getCtx, nodeName, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		adds := map[string]string{}
+		var removes []string
+		for key, value := range annotations {
+			if value != nil {
+				adds[key] = *value
+			} else if _, exists := node.Annotations[key]; exists {
+				removes = append(removes, key)
+			}
+		}
+		operations := buildAnnotationJSONPatch(adds, removes)
+		if len(operations) == 0 {
+			return nil
+		}
+		if node.Annotations == nil {
+			operations = append([]JSONPatchOperation{{Op: "add", Path: "/metadata/annotations", Value: map[string]string{}}}, operations...)
+		}
+		// A failed test operation is rejected as invalid, which is retried
+		if node.ResourceVersion != "" {
+			operations = append([]JSONPatchOperation{{Op: "test", Path: "/metadata/resourceVersion", Value: node.ResourceVersion}}, operations...)
+		}
+		patch, err := json.Marshal(operations)
+		if err != nil {
+			return fmt.Errorf("failed to build annotation patch: %w", err)
+		}
+		if dryRun {
+			logger.Info("Dry run: would patch node", "patch", string(patch))
+			return nil
+		}
+
+		patchCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+		defer cancel()
+		_, err = client.CoreV1().Nodes().Patch(patchCtx, nodeName, types.JSONPatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+}
+
+// The outcome of evaluating a node for reboot, with the reason it was allowed or blocked.
+// requeue is set when the node should be retried later rather than dropped.
+type rebootDecision struct {
+	reboot  bool
+	requeue bool
+	reason  string
+}
+
+// Reasons reported by shouldReboot, one per gating condition
+const (
+	reasonNoReboot           = "no-reboot annotation set"
+	reasonNoRebootOverride   = "no-reboot annotation set, overriding reboot annotations"
+	reasonInProgress         = "reboot already in progress"
+	reasonInProgressOverride = "reboot already in progress, ignoring reboot annotation"
+	reasonRequested          = "reboot annotation set"
+	reasonOutsideWindow      = "reboot annotation set, waiting for the maintenance window"
+	reasonConcurrencyLimit   = "reboot annotation set, waiting for a free reboot slot"
+	reasonManualDrain        = "reboot annotation set, waiting for a manual drain to finish"
+	reasonRebootNeeded       = "reboot-needed set but no reboot requested"
+	reasonNotRequested       = "no reboot requested"
+)
+
+// shouldReboot decides whether a reboot should start on the node. Conflicting annotations
+// are resolved in this order of precedence:
+//
+//	no-reboot > reboot-in-progress > reboot > reboot-needed
+//
+// no-reboot always blocks, a reboot already in progress is never started again, and
+// reboot-needed on its own only marks the node as pending. A node that would reboot must also
+// be inside the maintenance window at now and get a slot from the limiter, otherwise it is
+// requeued. A node in progress holds its slot until the reboot completes.
+func shouldReboot(logger *slog.Logger, node *v1.Node, keys AnnotationKeys, window *MaintenanceWindow, now time.Time, limiter *rebootLimiter) rebootDecision {
+	_, noReboot := node.Annotations[keys.NoReboot]
+	reboot := rebootRequested(logger, node.Annotations, keys)
+	_, rebootNeeded := node.Annotations[keys.RebootNeeded]
+	_, inProgress := node.Annotations[keys.RebootInProgress]
+	if inProgress {
+		// Count it against the limit until it completes, whoever started it
+		limiter.hold(node.Name, limiter.group(node))
+	}
 
-	// if _, exists := annotations[RebootAnnotation]; exists {
-	// 	fmt.Printf("Reboot annotation found on node %s. Initiating reboot.\n", node.Name)
-	// 	initiateReboot(node.Name)
-	// } else if _, exists := annotations[RebootNeededAnnotation]; exists {
-	// 	fmt.Printf("Reboot needed annotation found on node %s.\n", node.Name)
-	// } else if _, exists := annotations[RebootInProgressAnnotation]; exists {
-	// 	fmt.Printf("Reboot in progress annotation found on node %s.\n", node.Name)
-	// }
+	switch {
+	case noReboot && (reboot || inProgress):
+		return rebootDecision{reason: reasonNoRebootOverride}
+	case noReboot:
+		return rebootDecision{reason: reasonNoReboot}
+	case inProgress && reboot:
+		return rebootDecision{reason: reasonInProgressOverride}
+	case inProgress:
+		return rebootDecision{reason: reasonInProgress}
+	case reboot && !window.Allows(now):
+		return rebootDecision{requeue: true, reason: reasonOutsideWindow}
+	case reboot && !limiter.tryAcquire(node.Name, limiter.group(node)):
+		return rebootDecision{requeue: true, reason: reasonConcurrencyLimit}
+	case reboot:
+		return rebootDecision{reboot: true, reason: reasonRequested}
+	case rebootNeeded:
+		return rebootDecision{reason: reasonRebootNeeded}
+	default:
+		return rebootDecision{reason: reasonNotRequested}
+	}
 }
 
-func shouldReboot(node *v1.Node) bool {
-	_, reboot := node.Annotations[RebootAnnotation]
-	_, inProgress := node.Annotations[RebootInProgressAnnotation]
+// Helper function to check whether the reboot annotation is set to a true value or a payload
+func rebootRequested(logger *slog.Logger, annotations map[string]string, keys AnnotationKeys) bool {
+	return rebootPayload(logger, annotations, keys) != nil
+}
 
-	return reboot && !inProgress
+// Helper function to get the reboot request from the reboot annotation, nil if there is none.
+// Invalid values, including malformed JSON, are logged and treated as no reboot.
+func rebootPayload(logger *slog.Logger, annotations map[string]string, keys AnnotationKeys) *RebootRequestPayload {
+	value, exists := annotations[keys.Reboot]
+	if !exists {
+		return nil
+	}
+	payload, err := parseRebootAnnotation(value)
+	if err != nil {
+		logger.Warn("Ignoring invalid reboot annotation", "annotation", keys.Reboot, "error", err)
+		return nil
+	}
+	return payload
 }
 
-func rebootInProgress(node *v1.Node) bool {
-	_, inProgress := node.Annotations[RebootInProgressAnnotation]
+// parseRebootFlag parses a reboot annotation value. An empty value means true, as the
+// annotation used to be a bare marker.
+func parseRebootFlag(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "true", "1", "yes":
+		return true, nil
+	case "false", "0", "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid value %q: must be true, false, 1, 0, yes or no", value)
+	}
+}
+
+func rebootInProgress(node *v1.Node, keys AnnotationKeys) bool {
+	_, inProgress := node.Annotations[keys.RebootInProgress]
 	return inProgress
 }
 
-// Function to initiate a reboot on the node
-func initiateReboot(nodeName string) {
-	fmt.Printf("Rebooting node %s\n", nodeName)
-	// This is a simple example of how you might initiate a reboot. In a real-world scenario, you would
-	// need to securely communicate with the node to initiate the reboot.
-	// cmd := exec.Command("ssh", nodeName, "sudo", "reboot")
-	// if err := cmd.Run(); err != nil {
-	//     fmt.Printf("Failed to reboot node %s: %v\n", nodeName, err)
-	// } else {
-	//     fmt.Printf("Node %s is rebooting.\n", nodeName)
-	// }
+// Reboot counts stop growing here, so a bogus annotation can't make them absurd
+const maxRebootCount = 1_000_000
+
+// Helper function to read how many reboots the agent has completed on the node. A missing or
+// invalid count reads as 0, and counts are capped at maxRebootCount-1 so the next one fits.
+func rebootCount(node *v1.Node, keys AnnotationKeys) int {
+	count, err := strconv.Atoi(node.Annotations[keys.RebootCount])
+	if err != nil || count < 0 {
+		return 0
+	}
+	return min(count, maxRebootCount-1)
+}
+
+// Helper function to read when the agent last completed a reboot of the node, falling back to
+// the key earlier versions wrote. Empty if the node never rebooted.
+func lastReboot(node *v1.Node, keys AnnotationKeys) string {
+	if value, ok := node.Annotations[keys.LastReboot]; ok {
+		return value
+	}
+	return node.Annotations[keys.LegacyLastReboot]
+}
+
+// Helper function to check whether a node marked in progress has rebooted since: its boot ID
+// differs from the one recorded when the reboot started, or it became Ready after the start
+// time. An in-progress annotation without a start time predates this check and is treated as
+// finished, as it used to be.
+func rebootFinished(node *v1.Node, keys AnnotationKeys) bool {
+	startedAt, err := time.Parse(time.RFC3339, node.Annotations[keys.RebootInProgress])
+	if err != nil {
+		return true
+	}
+	bootID := node.Annotations[keys.BootID]
+	if bootID != "" && node.Status.NodeInfo.BootID != "" && node.Status.NodeInfo.BootID != bootID {
+		return true
+	}
+	ready := nodeReadyCondition(node)
+	return ready != nil && ready.Status == v1.ConditionTrue && ready.LastTransitionTime.After(startedAt)
+}
+
+// Helper function to list the reboot and reboot-needed annotations on a node, which contradict
+// a reboot in progress
+func contradictoryAnnotations(node *v1.Node, keys AnnotationKeys) []string {
+	var found []string
+	for _, key := range []string{keys.Reboot, keys.RebootNeeded} {
+		if _, exists := node.Annotations[key]; exists {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+// Helper function to check whether a node has been marked in progress for longer than timeout
+func rebootStuck(node *v1.Node, keys AnnotationKeys, timeout time.Duration, now time.Time) bool {
+	startedAt, err := time.Parse(time.RFC3339, node.Annotations[keys.RebootInProgress])
+	if err != nil {
+		return false
+	}
+	return now.Sub(startedAt) > timeout
+}
+
+// Helper function to check whether the node's boot ID changed between two versions of it
+func bootIDChanged(oldNode, newNode *v1.Node) bool {
+	oldID, newID := oldNode.Status.NodeInfo.BootID, newNode.Status.NodeInfo.BootID
+	return oldID != "" && newID != "" && oldID != newID
+}
+
+// Helper function to check whether the node's Ready condition changed between two versions of it
+func readyChanged(oldNode, newNode *v1.Node) bool {
+	oldReady, newReady := nodeReadyCondition(oldNode), nodeReadyCondition(newNode)
+	if oldReady == nil || newReady == nil {
+		return oldReady != newReady
+	}
+	return oldReady.Status != newReady.Status
+}
+
+// Helper function to find the node's Ready condition
+func nodeReadyCondition(node *v1.Node) *v1.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == v1.NodeReady {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
 }
 
 // Handle specific annotations
-func handlePodAnnotations(pod *v1.Pod, clientset *kubernetes.Clientset) {
+func (c *Controller) handlePodAnnotations(ctx context.Context, pod *v1.Pod) error {
+	keys := c.annotationKeys()
 	annotations := pod.Annotations
 	if annotations == nil {
-		return
+		return nil
 	}
+	logger := c.logger.With("pod", pod.Name, "namespace", pod.Namespace)
 
-	if _, exists := annotations[RebootAnnotation]; exists {
-		fmt.Printf("Reboot annotation found on pod %s. Restarting deployment.\n", pod.Name)
-		restartDeployment(pod, clientset)
-	} else if _, exists := annotations[RebootNeededAnnotation]; exists {
-		fmt.Printf("Reboot needed annotation found on pod %s.\n", pod.Name)
-	} else if _, exists := annotations[RebootInProgressAnnotation]; exists {
-		fmt.Printf("Reboot in progress annotation found on pod %s.\n", pod.Name)
+	if rebootRequested(logger, annotations, keys) {
+		logger.Info("Reboot annotation found on pod, restarting owning workload", "annotation", keys.Reboot)
+		return c.restartDeployment(ctx, logger, pod)
+	} else if _, exists := annotations[keys.RebootNeeded]; exists {
+		logger.Info("Reboot needed annotation found on pod", "annotation", keys.RebootNeeded)
+	} else if _, exists := annotations[keys.RebootInProgress]; exists {
+		logger.Info("Reboot in progress annotation found on pod", "annotation", keys.RebootInProgress)
 	}
+	return nil
 }
 
-// Function to restart the deployment of the pod
-func restartDeployment(pod *v1.Pod, clientset *kubernetes.Clientset) {
-	// Find the owner reference for the pod's deployment
-	for _, ownerRef := range pod.OwnerReferences {
-		if ownerRef.Kind == "ReplicaSet" {
-			replicaSet, err := clientset.AppsV1().ReplicaSets(pod.Namespace).Get(context.TODO(), ownerRef.Name, metav1.GetOptions{})
+// Function to restart the workload owning the pod: a Deployment, StatefulSet, DaemonSet, Job
+// or known rollout CR found by following the pod's controller references, through ReplicaSets
+// or any intermediate owners
+func (c *Controller) restartDeployment(ctx context.Context, logger *slog.Logger, pod *v1.Pod) error {
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil {
+		logger.Warn("Pod has no controller, nothing to restart")
+		return nil
+	}
+
+	owner, err := resolveTopOwner(ctx, c.dynamicClient, pod.Namespace, *controllerRef, c.ownerMaxDepth, c.apiTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to find the workload owning the pod: %w", err)
+	}
+	return c.triggerRolloutRestart(ctx, logger, owner, pod)
+}
+
+// Helper function to trigger a rollout restart of a workload by setting the restartedAt
+// annotation on its pod template, like `kubectl rollout restart`. Owners that are not
+// Deployments, StatefulSets or DaemonSets are handed to restartRollout, except Jobs, whose pod
+// is deleted instead.
+func (c *Controller) triggerRolloutRestart(ctx context.Context, logger *slog.Logger, owner metav1.OwnerReference, pod *v1.Pod) error {
+	logger = logger.With("kind", owner.Kind, "name", owner.Name)
+	namespace := pod.Namespace
+
+	// Don't roll the same workload again while clustered reboots keep hitting it
+	key := restartKey{kind: owner.Kind, NamespacedName: types.NamespacedName{Namespace: namespace, Name: owner.Name}}
+	unlock := c.restartCooldown.lock(key)
+	defer unlock()
+	if c.restartCooldown.active(key) {
+		return c.skipRestart(logger, pod, owner, fmt.Sprintf("restarted within the %s cooldown", c.restartCooldown.period))
+	}
+	// A restart since the pod was created already replaces it, e.g. one made for another of the
+	// workload's pods while this one waited for the lock. A Job restart only replaces its pod.
+	if owner.Kind != "Job" && c.restartCooldown.restartedSince(key, pod.CreationTimestamp.Time) {
+		return c.skipRestart(logger, pod, owner, "already restarted since the pod was created")
+	}
+	// The restart counts against the budget until it returns, rollout wait included
+	disruption := restartDisruption(key)
+	if !c.disruptions.tryAcquire(disruption) {
+		return &requeueError{reason: "waiting for a free disruption slot"}
+	}
+	defer c.disruptions.release(disruption)
+
+	// One timeout for the read-modify-write of the workload
+	waitCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, c.apiTimeout)
+	defer cancel()
+
+	var err error
+	switch owner.Kind {
+	case "Deployment":
+		// Re-read and re-stamp the Deployment on each attempt, another writer (e.g. the
+		// deployment controller updating its status) may have bumped its resourceVersion
+		var deployment *appsv1.Deployment
+		skipped := false
+		err = retry.RetryOnConflict(c.conflictBackoff, func() error {
+			attemptCtx, cancel := context.WithTimeout(waitCtx, c.apiTimeout)
+			defer cancel()
+			current, err := c.clientset.AppsV1().Deployments(namespace).Get(attemptCtx, owner.Name, metav1.GetOptions{})
 			if err != nil {
-				fmt.Printf("Failed to get replicaset: %v\n", err)
-				return
-			}
-			for _, ownerRef := range replicaSet.OwnerReferences {
-				if ownerRef.Kind == "Deployment" {
-					deployment, err := clientset.AppsV1().Deployments(pod.Namespace).Get(context.TODO(), ownerRef.Name, metav1.GetOptions{})
-					if err != nil {
-						fmt.Printf("Failed to get deployment: %v\n", err)
-						return
-					}
-					// Initialize the annotations map if it's nil
-					if deployment.Spec.Template.Annotations == nil {
-						deployment.Spec.Template.Annotations = make(map[string]string)
-					}
-					// Patch the deployment to trigger a restart
-					deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
-					_, err = clientset.AppsV1().Deployments(pod.Namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-					if err != nil {
-						fmt.Printf("Failed to update deployment: %v\n", err)
-					} else {
-						fmt.Printf("Deployment %s restarted.\n", deployment.Name)
-					}
-				}
+				return fmt.Errorf("failed to get deployment %s: %w", owner.Name, err)
+			}
+			// Also honour restarts made by other replicas or by hand
+			if restartedWithin(current.Spec.Template.Annotations, c.restartCooldown.period) {
+				skipped = true
+				return nil
+			}
+			setRestartedAt(&current.Spec.Template)
+			if c.dryRun {
+				deployment = current
+				return nil
 			}
+			deployment, err = c.clientset.AppsV1().Deployments(namespace).Update(attemptCtx, current, metav1.UpdateOptions{})
+			return err
+		})
+		if skipped {
+			return c.skipRestart(logger, pod, owner, fmt.Sprintf("restarted within the %s cooldown", c.restartCooldown.period))
+		}
+		if err == nil && c.dryRun {
+			return logDryRunRestart(logger, deployment.Spec.Template)
+		}
+		if err == nil && c.rolloutTimeout > 0 {
+			c.restarted(key)
+			logger.Info("Workload restarted, waiting for the rollout", "timeout", c.rolloutTimeout)
+			// Holding the workload's lock, so its other pods wait for the rollout too
+			waitForDeploymentRollout(waitCtx, logger, c.clientset, deployment, c.rolloutTimeout, c.apiTimeout)
+			return nil
+		}
+	case "StatefulSet":
+		var statefulSet *appsv1.StatefulSet
+		statefulSet, err = c.clientset.AppsV1().StatefulSets(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get statefulset %s: %w", owner.Name, err)
+		}
+		if restartedWithin(statefulSet.Spec.Template.Annotations, c.restartCooldown.period) {
+			return c.skipRestart(logger, pod, owner, fmt.Sprintf("restarted within the %s cooldown", c.restartCooldown.period))
+		}
+		setRestartedAt(&statefulSet.Spec.Template)
+		if c.dryRun {
+			return logDryRunRestart(logger, statefulSet.Spec.Template)
+		}
+		_, err = c.clientset.AppsV1().StatefulSets(namespace).Update(ctx, statefulSet, metav1.UpdateOptions{})
+	case "DaemonSet":
+		var daemonSet *appsv1.DaemonSet
+		daemonSet, err = c.clientset.AppsV1().DaemonSets(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get daemonset %s: %w", owner.Name, err)
+		}
+		if restartedWithin(daemonSet.Spec.Template.Annotations, c.restartCooldown.period) {
+			return c.skipRestart(logger, pod, owner, fmt.Sprintf("restarted within the %s cooldown", c.restartCooldown.period))
+		}
+		setRestartedAt(&daemonSet.Spec.Template)
+		if c.dryRun {
+			return logDryRunRestart(logger, daemonSet.Spec.Template)
+		}
+		_, err = c.clientset.AppsV1().DaemonSets(namespace).Update(ctx, daemonSet, metav1.UpdateOptions{})
+	case "Job":
+		restarted, err := restartJobPod(ctx, logger, c.clientset, owner, pod, c.dryRun)
+		if err != nil {
+			return err
+		}
+		if restarted {
+			c.restarted(key)
+		}
+		return nil
+	default:
+		// Rollout CRs (e.g. Argo Rollouts) own ReplicaSets directly in place of a Deployment
+		restarted, err := restartRollout(ctx, logger, namespace, owner, c.dynamicClient, c.dryRun)
+		if err != nil {
+			return err
 		}
+		if restarted {
+			c.restarted(key)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s %s: %w", owner.Kind, owner.Name, err)
+	}
+	c.restarted(key)
+	logger.Info("Workload restarted")
+	return nil
+}
+
+// Helper function to note a workload restart: its cooldown starts and it is counted in
+// reboot_agent_deployment_restarts_total
+func (c *Controller) restarted(key restartKey) {
+	c.restartCooldown.record(key)
+	deploymentRestartsTotal.WithLabelValues(key.Namespace, key.kind).Inc()
+}
+
+// Helper function to log and record on the pod that its workload's restart was skipped, and why
+func (c *Controller) skipRestart(logger *slog.Logger, pod *v1.Pod, owner metav1.OwnerReference, why string) error {
+	logger.Info("Skipping workload restart", "why", why)
+	c.recorder.Eventf(pod, v1.EventTypeNormal, eventRestartSkipped, "Skipped restarting %s %s, %s", owner.Kind, owner.Name, why)
+	return nil
+}
+
+// Helper function to log the restart triggerRolloutRestart would have made in dry-run mode
+func logDryRunRestart(logger *slog.Logger, template v1.PodTemplateSpec) error {
+	logger.Info("Dry run: would restart workload", "annotation", restartedAtAnnotation, "value", template.Annotations[restartedAtAnnotation])
+	return nil
+}
+
+// Helper function to stamp the restartedAt annotation on a pod template, which rolls the workload
+func setRestartedAt(template *v1.PodTemplateSpec) {
+	// Initialize the annotations map if it's nil
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[restartedAtAnnotation] = time.Now().Format(time.RFC3339)
+}
+
+// Helper function to check whether a pod template was restarted within the cooldown, based on
+// its restartedAt annotation
+func restartedWithin(annotations map[string]string, cooldown time.Duration) bool {
+	if cooldown <= 0 {
+		return false
+	}
+	restartedAt, err := time.Parse(time.RFC3339, annotations[restartedAtAnnotation])
+	if err != nil {
+		return false
 	}
+	return time.Since(restartedAt) < cooldown
 }
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+)
+
+// Helper function to build a logger that drops everything
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Helper function to get the annotation keys under the default prefix
+func testKeys(t *testing.T) AnnotationKeys {
+	t.Helper()
+	keys, err := newAnnotationKeys(defaultAnnotationPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// Helper function to build a node with the given annotations
+func testNode(name string, annotations map[string]string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func TestShouldRebootPrecedence(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		noReboot, inProgress, reboot, rebootNeeded bool
+		want                                       rebootDecision
+	}{
+		{false, false, false, false, rebootDecision{reason: reasonNotRequested}},
+		{false, false, false, true, rebootDecision{reason: reasonRebootNeeded}},
+		{false, false, true, false, rebootDecision{reboot: true, reason: reasonRequested}},
+		{false, false, true, true, rebootDecision{reboot: true, reason: reasonRequested}},
+		{false, true, false, false, rebootDecision{reason: reasonInProgress}},
+		{false, true, false, true, rebootDecision{reason: reasonInProgress}},
+		{false, true, true, false, rebootDecision{reason: reasonInProgressOverride}},
+		{false, true, true, true, rebootDecision{reason: reasonInProgressOverride}},
+		{true, false, false, false, rebootDecision{reason: reasonNoReboot}},
+		{true, false, false, true, rebootDecision{reason: reasonNoReboot}},
+		{true, false, true, false, rebootDecision{reason: reasonNoRebootOverride}},
+		{true, false, true, true, rebootDecision{reason: reasonNoRebootOverride}},
+		{true, true, false, false, rebootDecision{reason: reasonNoRebootOverride}},
+		{true, true, false, true, rebootDecision{reason: reasonNoRebootOverride}},
+		{true, true, true, false, rebootDecision{reason: reasonNoRebootOverride}},
+		{true, true, true, true, rebootDecision{reason: reasonNoRebootOverride}},
+	}
+	for _, tt := range tests {
+		annotations := map[string]string{}
+		if tt.noReboot {
+			annotations[keys.NoReboot] = ""
+		}
+		if tt.inProgress {
+			annotations[keys.RebootInProgress] = "2024-01-01T00:00:00Z"
+		}
+		if tt.reboot {
+			annotations[keys.Reboot] = "true"
+		}
+		if tt.rebootNeeded {
+			annotations[keys.RebootNeeded] = ""
+		}
+		got := shouldReboot(discardLogger(), testNode("node-1", annotations), keys, nil, time.Now(), newRebootLimiter(1))
+		if got != tt.want {
+			t.Errorf("no-reboot=%v in-progress=%v reboot=%v reboot-needed=%v: got %+v, want %+v",
+				tt.noReboot, tt.inProgress, tt.reboot, tt.rebootNeeded, got, tt.want)
+		}
+	}
+}
+
+func TestShouldRebootGates(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	window, err := parseMaintenanceWindow("22:00-02:00", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	midnight := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if got := shouldReboot(discardLogger(), node, keys, window, noon, newRebootLimiter(1)); got != (rebootDecision{requeue: true, reason: reasonOutsideWindow}) {
+		t.Errorf("outside the window: got %+v", got)
+	}
+	full := newRebootLimiter(1)
+	full.tryAcquire("node-2", "")
+	if got := shouldReboot(discardLogger(), node, keys, window, midnight, full); got != (rebootDecision{requeue: true, reason: reasonConcurrencyLimit}) {
+		t.Errorf("no free slot: got %+v", got)
+	}
+	if got := shouldReboot(discardLogger(), node, keys, window, midnight, newRebootLimiter(1)); got != (rebootDecision{reboot: true, reason: reasonRequested}) {
+		t.Errorf("inside the window with a free slot: got %+v", got)
+	}
+}
+
+func TestWaitForCacheSyncTimesOut(t *testing.T) {
+	informers := []namedInformer{
+		{name: "nodes", synced: func() bool { return true }},
+		{name: "pods", synced: func() bool { return false }},
+	}
+	start := time.Now()
+	unsynced := waitForCacheSync(make(chan struct{}), 200*time.Millisecond, informers)
+	if len(unsynced) != 1 || unsynced[0] != "pods" {
+		t.Errorf("unsynced = %v, want [pods]", unsynced)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waitForCacheSync took %v, want it to give up after the timeout", elapsed)
+	}
+}
+
+// Helper function to build a Deployment with one replica, optionally restarted at the given time
+func testDeployment(namespace, name string, restartedAt time.Time) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(int32(1))},
+	}
+	if !restartedAt.IsZero() {
+		deployment.Spec.Template.Annotations = map[string]string{restartedAtAnnotation: restartedAt.Format(time.RFC3339)}
+	}
+	return deployment
+}
+
+func TestRestartSkippedEvent(t *testing.T) {
+	client := fake.NewSimpleClientset(testDeployment("default", "web", time.Now().Add(-time.Minute)))
+	recorder := record.NewFakeRecorder(10)
+	pod := testPod("default", "web-abc", "node-1", "ReplicaSet")
+	owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+
+	err := newTestRestarter(t, client, nil, recorder, newRestartCooldown(5*time.Minute)).triggerRolloutRestart(context.Background(), discardLogger(), owner, pod)
+	if err != nil {
+		t.Fatalf("triggerRolloutRestart() failed: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("deployment restarted within the cooldown: %v", action)
+		}
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Normal "+eventRestartSkipped+" ") {
+			t.Errorf("event = %q, want a Normal %s event", event, eventRestartSkipped)
+		}
+	default:
+		t.Errorf("no %s event recorded", eventRestartSkipped)
+	}
+}
+
+func TestPatchNodeAnnotationsIgnoresStaleCache(t *testing.T) {
+	keys := testKeys(t)
+	// The cached copy the controller worked from only had the reboot annotation; since then
+	// someone else added reboot-needed and an unrelated annotation, bumping the version
+	stale := testNode("node-1", map[string]string{keys.Reboot: ""})
+	stale.ResourceVersion = "1"
+	current := testNode("node-1", map[string]string{keys.Reboot: "", keys.RebootNeeded: "", "team": "payments"})
+	current.ResourceVersion = "2"
+	client := fake.NewSimpleClientset(current)
+
+	err := patchNodeAnnotations(context.Background(), discardLogger(), client, stale.Name, time.Second, retry.DefaultBackoff, false, map[string]*string{
+		keys.RebootInProgress: ptr.To("2024-01-01T00:00:00Z"),
+		keys.Reboot:           nil,
+		keys.RebootNeeded:     nil,
+	})
+	if err != nil {
+		t.Fatalf("patchNodeAnnotations() failed: %v", err)
+	}
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{keys.RebootInProgress: "2024-01-01T00:00:00Z", "team": "payments"}
+	if !equalAnnotations(got.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", got.Annotations, want)
+	}
+}
+
+func TestNewInformerFactoryScoping(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testPod("team-a", "web-1", "node-1", "ReplicaSet"),
+		testPod("team-b", "web-2", "node-1", "ReplicaSet"),
+		testNode("node-1", nil),
+	)
+	tests := []struct {
+		namespace string
+		wantPods  int
+	}{
+		{"", 2},
+		{"team-a", 1},
+	}
+	for _, tt := range tests {
+		factory := newInformerFactory(client, 0, tt.namespace)
+		pods := factory.Core().V1().Pods()
+		nodes := factory.Core().V1().Nodes()
+		pods.Informer()
+		nodes.Informer()
+		stopCh := make(chan struct{})
+		factory.Start(stopCh)
+		factory.WaitForCacheSync(stopCh)
+
+		if got, _ := pods.Lister().List(labels.Everything()); len(got) != tt.wantPods {
+			t.Errorf("namespace %q: cached %d pods, want %d", tt.namespace, len(got), tt.wantPods)
+		}
+		if got, _ := nodes.Lister().List(labels.Everything()); len(got) != 1 {
+			t.Errorf("namespace %q: cached %d nodes, want the cluster-scoped node regardless", tt.namespace, len(got))
+		}
+		close(stopCh)
+		factory.Shutdown()
+	}
+}
+
+func TestAgentInformerScoping(t *testing.T) {
+	tests := []struct {
+		nodeName            string
+		nodeField, podField string
+	}{
+		{"", "", ""},
+		{"node-1", "metadata.name=node-1", "spec.nodeName=node-1"},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset()
+		listed := map[string]string{}
+		var mu sync.Mutex
+		client.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			listed[action.GetResource().Resource] = action.(k8stesting.ListAction).GetListRestrictions().Fields.String()
+			return false, nil, nil
+		})
+		factory := newInformerFactory(client, 0, "")
+		newNodeInformer(factory, labels.Everything(), tt.nodeName)
+		newPodInformer(factory, "", tt.nodeName)
+		stopCh := make(chan struct{})
+		factory.Start(stopCh)
+		factory.WaitForCacheSync(stopCh)
+		close(stopCh)
+		factory.Shutdown()
+
+		if listed["nodes"] != tt.nodeField || listed["pods"] != tt.podField {
+			t.Errorf("node name %q: listed nodes with %q and pods with %q, want %q and %q", tt.nodeName, listed["nodes"], listed["pods"], tt.nodeField, tt.podField)
+		}
+	}
+}
+
+func TestFlagSet(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("max-concurrent-reboots", 1, "")
+	flags.Int("workers", 1, "")
+	flags.String("mode", modeController, "")
+	if err := flags.Parse([]string{"--mode=agent"}); err != nil {
+		t.Fatal(err)
+	}
+	if flagSet(flags, "max-concurrent-reboots") {
+		t.Error("flag left at its default reported as set")
+	}
+	if !flagSet(flags, "mode") {
+		t.Error("flag set on the command line not reported as set")
+	}
+	// Values from the config file count as set too
+	config := &Config{MaxConcurrentReboots: ptr.To(1)}
+	if err := config.apply(flags); err != nil {
+		t.Fatal(err)
+	}
+	if !flagSet(flags, "max-concurrent-reboots") {
+		t.Error("flag set by the config file not reported as set, even to its default value")
+	}
+}
+
+func TestRestartDeploymentOwners(t *testing.T) {
+	// web-1 is owned by a ReplicaSet, which the Deployment web owns
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "web-1-owner",
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: ptr.To(true)}},
+	}}
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		resource string // Updated workload resource, empty for none
+		kind     string // Kind counted in reboot_agent_deployment_restarts_total
+	}{
+		{"Deployment", testPod("default", "web-1", "node-1", "ReplicaSet"), "deployments", "Deployment"},
+		{"StatefulSet", testPod("default", "db-0", "node-1", "StatefulSet"), "statefulsets", "StatefulSet"},
+		{"DaemonSet", testPod("default", "agent-x", "node-1", "DaemonSet"), "daemonsets", "DaemonSet"},
+		{"bare pod", testPod("default", "debug", "node-1", ""), "", ""},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(
+			testDeployment("default", "web", time.Time{}),
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0-owner"}},
+			&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "agent-x-owner"}},
+		)
+		dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme.Scheme, replicaSet)
+		restarts := testutil.ToFloat64(deploymentRestartsTotal.WithLabelValues("default", tt.kind))
+
+		err := newTestRestarter(t, client, dynamicClient, record.NewFakeRecorder(10), newRestartCooldown(0)).restartDeployment(context.Background(), discardLogger(), tt.pod)
+		if err != nil {
+			t.Fatalf("%s: restartDeployment() failed: %v", tt.name, err)
+		}
+		var updated []string
+		for _, action := range client.Actions() {
+			if update, ok := action.(k8stesting.UpdateAction); ok {
+				updated = append(updated, action.GetResource().Resource)
+				var template v1.PodTemplateSpec
+				switch workload := update.GetObject().(type) {
+				case *appsv1.Deployment:
+					template = workload.Spec.Template
+				case *appsv1.StatefulSet:
+					template = workload.Spec.Template
+				case *appsv1.DaemonSet:
+					template = workload.Spec.Template
+				}
+				if template.Annotations[restartedAtAnnotation] == "" {
+					t.Errorf("%s: %s updated without %s", tt.name, action.GetResource().Resource, restartedAtAnnotation)
+				}
+			}
+		}
+		if tt.resource == "" {
+			if len(updated) != 0 {
+				t.Errorf("%s: updated %v, want nothing", tt.name, updated)
+			}
+			continue
+		}
+		if len(updated) != 1 || updated[0] != tt.resource {
+			t.Errorf("%s: updated %v, want [%s]", tt.name, updated, tt.resource)
+		}
+		if got := testutil.ToFloat64(deploymentRestartsTotal.WithLabelValues("default", tt.kind)) - restarts; got != 1 {
+			t.Errorf("%s: reboot_agent_deployment_restarts_total{namespace=\"default\",workload_kind=%q} rose by %v, want 1", tt.name, tt.kind, got)
+		}
+	}
+}
+
+func TestDryRunMakesNoWrites(t *testing.T) {
+	keys := testKeys(t)
+	rebooted := testNode("node-2", map[string]string{keys.RebootInProgress: time.Now().Add(-5 * time.Minute).UTC().Format(time.RFC3339), keys.BootID: "boot-1"})
+	rebooted.Status.NodeInfo.BootID = "boot-2"
+	pod := testPod("default", "db-0", "node-1", "StatefulSet")
+	pod.Annotations = map[string]string{keys.Reboot: ""}
+	c, client := newTestController(t,
+		testNode("node-1", map[string]string{keys.Reboot: ""}),
+		rebooted,
+		testPod("default", "web-1", "node-1", "ReplicaSet"),
+		pod,
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0-owner"}},
+	)
+	var logs strings.Builder
+	c.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	c.dryRun = true
+	completed := testutil.ToFloat64(rebootsCompletedTotal.WithLabelValues(ReasonOther))
+	client.ClearActions()
+
+	for _, sync := range []struct {
+		fn  func(context.Context, string) error
+		key string
+	}{{c.syncNode, "node-1"}, {c.syncNode, "node-2"}, {c.syncPod, "default/db-0"}} {
+		if err := sync.fn(context.Background(), sync.key); err != nil {
+			t.Fatalf("sync %s failed: %v", sync.key, err)
+		}
+	}
+	for _, action := range client.Actions() {
+		switch action.GetVerb() {
+		case "create", "update", "patch", "delete":
+			t.Errorf("dry run wrote to the cluster: %s %s/%s", action.GetVerb(), action.GetResource().Resource, action.GetSubresource())
+		}
+	}
+	for _, line := range []string{"Dry run: would patch node", "Dry run: would evict pod", "Dry run: reboot not started", "Dry run: would restart workload"} {
+		if !strings.Contains(logs.String(), line) {
+			t.Errorf("log is missing %q", line)
+		}
+	}
+	if got := testutil.ToFloat64(rebootsCompletedTotal.WithLabelValues(ReasonOther)) - completed; got != 0 {
+		t.Errorf("reboots_completed_total grew by %v in dry-run, want 0", got)
+	}
+}
+
+func TestAPICallsTimeOut(t *testing.T) {
+	// An apiserver that never answers
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = patchNodeAnnotations(context.Background(), discardLogger(), client, "node-1", 100*time.Millisecond, retry.DefaultBackoff, false, map[string]*string{testKeys(t).Reboot: nil})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("patchNodeAnnotations() error = %v, want a deadline exceeded error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("patchNodeAnnotations() took %v against a hung apiserver", elapsed)
+	}
+}
+
+func TestPatchNodeAnnotationsRetriesConflicts(t *testing.T) {
+	keys := testKeys(t)
+	client := fake.NewSimpleClientset(testNode("node-1", map[string]string{keys.Reboot: ""}))
+	conflicts := 0
+	client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			conflicts++
+			return true, nil, apierrors.NewConflict(v1.Resource("nodes"), "node-1", errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	err := patchNodeAnnotations(context.Background(), discardLogger(), client, "node-1", time.Second, retry.DefaultBackoff, false, map[string]*string{
+		keys.RebootInProgress: ptr.To("2024-01-01T00:00:00Z"),
+		keys.Reboot:           nil,
+	})
+	if err != nil {
+		t.Fatalf("patchNodeAnnotations() failed: %v", err)
+	}
+	patches := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" {
+			patches++
+		}
+	}
+	if patches != 2 {
+		t.Errorf("patched %d times, want a conflict then one success", patches)
+	}
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{keys.RebootInProgress: "2024-01-01T00:00:00Z"}; !equalAnnotations(got.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", got.Annotations, want)
+	}
+}
+
+func TestPatchNodeAnnotationsRejectedIfNodeChanged(t *testing.T) {
+	keys := testKeys(t)
+	// Someone adds reboot-needed between the controller reading the node and patching it
+	before := testNode("node-1", map[string]string{keys.Reboot: ""})
+	before.ResourceVersion = "1"
+	current := testNode("node-1", map[string]string{keys.Reboot: "", keys.RebootNeeded: ""})
+	current.ResourceVersion = "2"
+	client := fake.NewSimpleClientset(current)
+	gets := 0
+	client.PrependReactor("get", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets == 1 {
+			return true, before.DeepCopy(), nil
+		}
+		return false, nil, nil
+	})
+	// Like the apiserver, reject a patch whose test operation fails as unprocessable
+	client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		var operations []JSONPatchOperation
+		if err := json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &operations); err != nil {
+			return true, nil, err
+		}
+		if len(operations) == 0 || operations[0].Op != "test" || operations[0].Path != "/metadata/resourceVersion" {
+			t.Errorf("patch %v doesn't start by testing the resourceVersion", operations)
+		} else if operations[0].Value != current.ResourceVersion {
+			return true, nil, apierrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "patch", v1.Resource("nodes"), "node-1", "test operation failed", 0, false)
+		}
+		return false, nil, nil
+	})
+
+	err := patchNodeAnnotations(context.Background(), discardLogger(), client, "node-1", time.Second, retry.DefaultBackoff, false, map[string]*string{
+		keys.RebootInProgress: ptr.To("2024-01-01T00:00:00Z"),
+		keys.Reboot:           nil,
+		keys.RebootNeeded:     nil,
+	})
+	if err != nil {
+		t.Fatalf("patchNodeAnnotations() failed: %v", err)
+	}
+	if gets != 2 {
+		t.Errorf("read the node %d times, want the rejected patch rebuilt from a second read", gets)
+	}
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{keys.RebootInProgress: "2024-01-01T00:00:00Z"}; !equalAnnotations(got.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", got.Annotations, want)
+	}
+}
+
+func TestRebootFinished(t *testing.T) {
+	keys := testKeys(t)
+	startedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rebooting := func(bootID string, ready *v1.NodeCondition) *v1.Node {
+		node := testNode("node-1", map[string]string{keys.RebootInProgress: startedAt.Format(time.RFC3339), keys.BootID: "boot-1"})
+		node.Status.NodeInfo.BootID = bootID
+		if ready != nil {
+			node.Status.Conditions = []v1.NodeCondition{*ready}
+		}
+		return node
+	}
+	readySince := func(status v1.ConditionStatus, at time.Time) *v1.NodeCondition {
+		return &v1.NodeCondition{Type: v1.NodeReady, Status: status, LastTransitionTime: metav1.NewTime(at)}
+	}
+	tests := []struct {
+		name string
+		node *v1.Node
+		want bool
+	}{
+		{"boot ID unchanged", rebooting("boot-1", nil), false},
+		{"boot ID changed", rebooting("boot-2", nil), true},
+		{"ready since before the reboot", rebooting("boot-1", readySince(v1.ConditionTrue, startedAt.Add(-time.Hour))), false},
+		{"ready again after the reboot", rebooting("boot-1", readySince(v1.ConditionTrue, startedAt.Add(time.Minute))), true},
+		{"not ready after the reboot", rebooting("boot-1", readySince(v1.ConditionFalse, startedAt.Add(time.Minute))), false},
+		{"no start time", testNode("node-1", map[string]string{keys.RebootInProgress: ""}), true},
+	}
+	for _, tt := range tests {
+		if got := rebootFinished(tt.node, keys); got != tt.want {
+			t.Errorf("%s: rebootFinished() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	withBootID := func(bootID string) *v1.Node {
+		node := testNode("node-1", nil)
+		node.Status.NodeInfo.BootID = bootID
+		return node
+	}
+	if bootIDChanged(withBootID("boot-1"), withBootID("boot-1")) {
+		t.Error("bootIDChanged() with the same boot ID")
+	}
+	if !bootIDChanged(withBootID("boot-1"), withBootID("boot-2")) {
+		t.Error("bootIDChanged() missed a new boot ID")
+	}
+	if bootIDChanged(withBootID(""), withBootID("boot-2")) {
+		t.Error("bootIDChanged() on a node reporting its boot ID for the first time")
+	}
+}
+
+func TestRebootInProgressClearsContradictions(t *testing.T) {
+	keys := testKeys(t)
+	startedAt := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	node := testNode("node-1", map[string]string{
+		keys.RebootInProgress: startedAt,
+		keys.BootID:           "boot-1",
+		keys.Reboot:           "",
+		keys.RebootNeeded:     "",
+	})
+	node.Status.NodeInfo.BootID = "boot-1"
+	c, client := newTestController(t, node)
+	var logs strings.Builder
+	c.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatalf("syncNode() failed: %v", err)
+	}
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contradictory := contradictoryAnnotations(got, keys); len(contradictory) > 0 {
+		t.Errorf("contradictory annotations %v left on the node", contradictory)
+	}
+	if got.Annotations[keys.RebootInProgress] != startedAt {
+		t.Errorf("%s = %q, want the reboot still in progress since %s", keys.RebootInProgress, got.Annotations[keys.RebootInProgress], startedAt)
+	}
+	// Started an hour ago against a 30 minute timeout, and the boot ID never changed
+	if !strings.Contains(logs.String(), "Node has not come back from reboot") {
+		t.Errorf("stuck reboot not reported, logs:\n%s", logs.String())
+	}
+}
+
+func TestRebootStuck(t *testing.T) {
+	keys := testKeys(t)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		startedAt string
+		want      bool
+	}{
+		{"within the timeout", now.Add(-10 * time.Minute).Format(time.RFC3339), false},
+		{"past the timeout", now.Add(-31 * time.Minute).Format(time.RFC3339), true},
+		{"unparseable", "yesterday", false},
+	}
+	for _, tt := range tests {
+		node := testNode("node-1", map[string]string{keys.RebootInProgress: tt.startedAt})
+		if got := rebootStuck(node, keys, 30*time.Minute, now); got != tt.want {
+			t.Errorf("%s: rebootStuck() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRebootCompletionRecordsHistory(t *testing.T) {
+	keys := testKeys(t)
+	if keys.LastReboot != defaultAnnotationPrefix+"/last-rebooted" {
+		t.Errorf("last reboot key = %q, want %s/last-rebooted", keys.LastReboot, defaultAnnotationPrefix)
+	}
+	node := testNode("node-1", map[string]string{
+		keys.Reboot:           "",
+		keys.RebootCount:      "2",
+		keys.LegacyLastReboot: "2024-01-01T00:00:00Z", // Written by an earlier version
+	})
+	node.Status.NodeInfo.BootID = "boot-1"
+	c, client := newTestController(t, node)
+	start := time.Now().Truncate(time.Second)
+	rebootCycle(t, c, client, "node-1")
+
+	got, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastRebooted, err := time.Parse(time.RFC3339, got.Annotations[keys.LastReboot])
+	if err != nil || lastRebooted.Before(start) || lastRebooted.After(time.Now()) {
+		t.Errorf("%s = %q, want the completion time", keys.LastReboot, got.Annotations[keys.LastReboot])
+	}
+	if _, ok := got.Annotations[keys.LegacyLastReboot]; ok {
+		t.Errorf("legacy %s left on the node", keys.LegacyLastReboot)
+	}
+	if got.Annotations[keys.RebootCount] != "3" {
+		t.Errorf("%s = %q, want 3", keys.RebootCount, got.Annotations[keys.RebootCount])
+	}
+}
+
+func TestRebootHistoryReads(t *testing.T) {
+	keys := testKeys(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		lastReboot  string
+		count       int
+	}{
+		{"never rebooted", nil, "", 0},
+		{"legacy key", map[string]string{keys.LegacyLastReboot: "2024-01-01T00:00:00Z"}, "2024-01-01T00:00:00Z", 0},
+		{"new key wins", map[string]string{keys.LegacyLastReboot: "2024-01-01T00:00:00Z", keys.LastReboot: "2024-06-01T00:00:00Z"}, "2024-06-01T00:00:00Z", 0},
+		{"count", map[string]string{keys.RebootCount: "7"}, "", 7},
+		{"invalid count", map[string]string{keys.RebootCount: "many"}, "", 0},
+		{"negative count", map[string]string{keys.RebootCount: "-3"}, "", 0},
+		{"capped count", map[string]string{keys.RebootCount: "99999999999"}, "", maxRebootCount - 1},
+	}
+	for _, tt := range tests {
+		node := testNode("node-1", tt.annotations)
+		if got := lastReboot(node, keys); got != tt.lastReboot {
+			t.Errorf("%s: lastReboot() = %q, want %q", tt.name, got, tt.lastReboot)
+		}
+		if got := rebootCount(node, keys); got != tt.count {
+			t.Errorf("%s: rebootCount() = %d, want %d", tt.name, got, tt.count)
+		}
+	}
+}
+
+func TestRestartDeploymentRetriesConflicts(t *testing.T) {
+	client := fake.NewSimpleClientset(testDeployment("default", "web", time.Time{}))
+	conflicts := 0
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			conflicts++
+			// Another writer got in first: scale the stored Deployment and reject our stale copy
+			deployment, err := client.Tracker().Get(appsv1.SchemeGroupVersion.WithResource("deployments"), "default", "web")
+			if err != nil {
+				return true, nil, err
+			}
+			scaled := deployment.(*appsv1.Deployment).DeepCopy()
+			scaled.Spec.Replicas = ptr.To(int32(3))
+			if err := client.Tracker().Update(appsv1.SchemeGroupVersion.WithResource("deployments"), scaled, "default"); err != nil {
+				return true, nil, err
+			}
+			return true, nil, apierrors.NewConflict(appsv1.Resource("deployments"), "web", errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+	pod := testPod("default", "web-1", "node-1", "ReplicaSet")
+	if err := newTestRestarter(t, client, nil, record.NewFakeRecorder(10), newRestartCooldown(0)).triggerRolloutRestart(context.Background(), discardLogger(), owner, pod); err != nil {
+		t.Fatalf("triggerRolloutRestart() failed: %v", err)
+	}
+	gets, updates := 0, 0
+	for _, action := range client.Actions() {
+		switch action.GetVerb() {
+		case "get":
+			gets++
+		case "update":
+			updates++
+		}
+	}
+	if gets != 2 || updates != 2 {
+		t.Errorf("%d gets and %d updates, want the Deployment re-read for a second update after the conflict", gets, updates)
+	}
+	got, err := client.AppsV1().Deployments("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Template.Annotations[restartedAtAnnotation] == "" {
+		t.Errorf("deployment not restarted, template annotations %v", got.Spec.Template.Annotations)
+	}
+	if ptr.Deref(got.Spec.Replicas, 0) != 3 {
+		t.Errorf("replicas = %d, want the other writer's 3 kept", ptr.Deref(got.Spec.Replicas, 0))
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Registry served on /metrics. A dedicated registry keeps the output to the agent's own
+// metrics plus the standard Go and process collectors.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	rebootRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reboot_requests_total",
+		Help: "Number of reboots the agent decided to start, by reason code.",
+	}, []string{"reason"})
+	rebootsCompletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reboots_completed_total",
+		Help: "Number of reboots that completed, by reason code.",
+	}, []string{"reason"})
+	rebootsFailedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reboots_failed_total",
+		Help: "Number of failed reboot steps, by the phase of the reboot flow that failed.",
+	}, []string{"phase"})
+	informerCachedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "informer_cached_objects",
+		Help: "Number of objects in each informer cache, sampled every --metrics-sample-interval.",
+	}, []string{"kind"})
+	cacheSyncDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "cache_sync_duration_seconds",
+		Help: "Time the informer caches took to sync at startup.",
+		// Small clusters sync in well under a second, large ones can take minutes
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+	watchErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "watch_errors_total",
+		Help: "Number of times an informer's list or watch on the apiserver failed, retries included, by informer.",
+	}, []string{"informer"})
+	watchReconnectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reboot_agent_watch_reconnects_total",
+		Help: "Number of times an informer's established watch dropped and it re-listed to reconnect, by informer. Retries that fail again only count in watch_errors_total.",
+	}, []string{"informer"})
+	rebootResultsDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "reboot_agent_reboot_results_dropped_total",
+		Help: "Total number of reboot results dropped because the embedding program's RebootResults channel was full.",
+	})
+	deploymentRestartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reboot_agent_deployment_restarts_total",
+		Help: "Number of workload restarts triggered by pod reboot annotations, by namespace and workload kind.",
+	}, []string{"namespace", "workload_kind"})
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "reboot_agent_queue_depth",
+		Help: "Number of nodes waiting in the node queue, updated as workers take nodes off it.",
+	})
+	oldestPendingRebootSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "reboot_agent_oldest_pending_reboot_seconds",
+		Help: "How long the node waiting longest for its requested reboot to start has been waiting, updated on every node reconcile.",
+	})
+	drainsInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "drains_in_progress",
+		Help: "Number of node drains currently running, bounded by --max-concurrent-drains.",
+	})
+	disruptionsInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "reboot_agent_disruptions_in_progress",
+		Help: "Number of node reboots and workload restarts in progress together, bounded by --max-total-disruptions.",
+	})
+	rebootDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "reboot_duration_seconds",
+		Help: "Time from a node being marked reboot-in-progress until the reboot was seen to complete.",
+		// Node reboots take minutes; the long tail catches nodes that were slow to come back
+		Buckets: []float64{30, 60, 120, 180, 300, 600, 900, 1800, 3600},
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		rebootRequestsTotal,
+		rebootsCompletedTotal,
+		rebootsFailedTotal,
+		rebootDurationSeconds,
+		rebootResultsDroppedTotal,
+		deploymentRestartsTotal,
+		queueDepth,
+		oldestPendingRebootSeconds,
+		drainsInProgress,
+		disruptionsInProgress,
+		watchErrorsTotal,
+		watchReconnectsTotal,
+		informerCachedObjects,
+		cacheSyncDurationSeconds,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// registerInProgressGauges adds the reboots_in_progress and reboot_stuck gauges to registry.
+// They are computed from the node cache on every scrape rather than tracked in memory, so they
+// stay correct across restarts.
+func registerInProgressGauges(registry prometheus.Registerer, nodeLister corelisters.NodeLister, keys AnnotationKeys, stuckTimeout time.Duration) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "reboots_in_progress",
+		Help: "Number of nodes carrying the reboot-in-progress annotation.",
+	}, func() float64 {
+		return countNodes(nodeLister, func(node *v1.Node) bool {
+			return rebootInProgress(node, keys)
+		})
+	}))
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "reboot_stuck",
+		Help: "Number of nodes marked reboot-in-progress for longer than --reboot-stuck-timeout.",
+	}, func() float64 {
+		now := time.Now()
+		return countNodes(nodeLister, func(node *v1.Node) bool {
+			return rebootInProgress(node, keys) && rebootStuck(node, keys, stuckTimeout, now)
+		})
+	}))
+}
+
+// sampleCacheSizes sets informer_cached_objects for each store, by kind, every interval until
+// stopCh closes
+func sampleCacheSizes(stores map[string]cache.Store, interval time.Duration, stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		for kind, store := range stores {
+			informerCachedObjects.WithLabelValues(kind).Set(float64(len(store.ListKeys())))
+		}
+	}, interval, stopCh)
+}
+
+// Helper function to count the cached nodes matching a predicate
+func countNodes(nodeLister corelisters.NodeLister, match func(*v1.Node) bool) float64 {
+	nodes, err := nodeLister.List(labels.Everything())
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, node := range nodes {
+		if match(node) {
+			count++
+		}
+	}
+	return float64(count)
+}
+
+// serveMetrics serves /metrics, the reboot state handler on /reboots and, unless nil, the
+// partial drain handler on /drain, on addr until stopCh closes. Returns an error if addr can't
+// be bound.
+func serveMetrics(logger *slog.Logger, addr string, reboots, drain http.Handler, stopCh <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.Handle("/reboots", reboots)
+	if drain != nil {
+		mux.Handle("/drain", drain)
+	}
+	return serveHTTP(logger, "metrics", addr, mux, stopCh)
+}
+
+// Helper function to run an HTTP server in a goroutine, shutting it down when stopCh closes.
+// The address is bound before returning, so a port already in use is returned as an error
+// rather than only logged from the goroutine.
+func serveHTTP(logger *slog.Logger, name, addr string, handler http.Handler, stopCh <-chan struct{}) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s for the %s server: %w", addr, name, err)
+	}
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		logger.Info("Starting HTTP server", "server", name, "addr", listener.Addr().String())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server failed", "server", name, "addr", addr, "error", err)
+		}
+	}()
+	go func() {
+		<-stopCh
+		if err := server.Shutdown(context.Background()); err != nil {
+			logger.Warn("Failed to shut down HTTP server", "server", name, "error", err)
+		}
+	}()
+	return nil
+}
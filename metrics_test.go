@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Helper function to scrape a metric from a registry
+func scrapeMetric(t *testing.T, registry prometheus.Gatherer, name string) *dto.Metric {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name && len(family.GetMetric()) > 0 {
+			return family.GetMetric()[0]
+		}
+	}
+	t.Fatalf("metric %s not registered", name)
+	return nil
+}
+
+func TestRebootCycleMetrics(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	node.Status.NodeInfo.BootID = "boot-1"
+	c, client := newTestController(t, node)
+	// A registry of its own, as the gauges can only be registered once per registry
+	registry := prometheus.NewRegistry()
+	registerInProgressGauges(registry, c.nodeLister, keys, 30*time.Minute)
+	requests := testutil.ToFloat64(rebootRequestsTotal.WithLabelValues(ReasonOther))
+	completed := testutil.ToFloat64(rebootsCompletedTotal.WithLabelValues(ReasonOther))
+
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatalf("syncNode() starting the reboot failed: %v", err)
+	}
+	waitForCachedNode(t, c, "node-1", func(node *v1.Node) bool { return rebootInProgress(node, keys) })
+	if got := scrapeMetric(t, registry, "reboots_in_progress").GetGauge().GetValue(); got != 1 {
+		t.Errorf("reboots_in_progress = %v while rebooting, want 1", got)
+	}
+
+	started, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started.Status.NodeInfo.BootID = "boot-2"
+	if _, err := client.CoreV1().Nodes().Update(context.Background(), started, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForCachedNode(t, c, "node-1", func(node *v1.Node) bool { return node.Status.NodeInfo.BootID == "boot-2" })
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatalf("syncNode() completing the reboot failed: %v", err)
+	}
+	waitForCachedNode(t, c, "node-1", func(node *v1.Node) bool { return !rebootInProgress(node, keys) })
+
+	if got := testutil.ToFloat64(rebootRequestsTotal.WithLabelValues(ReasonOther)) - requests; got != 1 {
+		t.Errorf("reboot_requests_total{reason=%q} grew by %v, want 1", ReasonOther, got)
+	}
+	if got := testutil.ToFloat64(rebootsCompletedTotal.WithLabelValues(ReasonOther)) - completed; got != 1 {
+		t.Errorf("reboots_completed_total{reason=%q} grew by %v, want 1", ReasonOther, got)
+	}
+	if got := scrapeMetric(t, registry, "reboots_in_progress").GetGauge().GetValue(); got != 0 {
+		t.Errorf("reboots_in_progress = %v after the reboot, want 0", got)
+	}
+}
+
+func TestRebootDurationObserved(t *testing.T) {
+	keys := testKeys(t)
+	startedAt := time.Now().Add(-10 * time.Minute).UTC()
+	node := testNode("node-1", map[string]string{keys.RebootInProgress: startedAt.Format(time.RFC3339), keys.BootID: "boot-1"})
+	node.Status.NodeInfo.BootID = "boot-2"
+	c, _ := newTestController(t, node)
+	before := scrapeMetric(t, metricsRegistry, "reboot_duration_seconds").GetHistogram()
+
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatalf("syncNode() failed: %v", err)
+	}
+	after := scrapeMetric(t, metricsRegistry, "reboot_duration_seconds").GetHistogram()
+	if got := after.GetSampleCount() - before.GetSampleCount(); got != 1 {
+		t.Fatalf("reboot_duration_seconds observed %d times, want 1", got)
+	}
+	if got := after.GetSampleSum() - before.GetSampleSum(); got < 600 || got > 660 {
+		t.Errorf("observed a %vs reboot, want about 600s", got)
+	}
+}
+
+func TestSampleCacheSizes(t *testing.T) {
+	nodes := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, name := range []string{"node-1", "node-2", "node-3"} {
+		if err := nodes.Add(testNode(name, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	sampleCacheSizes(map[string]cache.Store{"test-nodes": nodes}, 10*time.Millisecond, stopCh)
+
+	sampled := func(want float64) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			return testutil.ToFloat64(informerCachedObjects.WithLabelValues("test-nodes")) == want, nil
+		})
+		if err != nil {
+			t.Errorf("informer_cached_objects{kind=\"test-nodes\"} = %v, want %v", testutil.ToFloat64(informerCachedObjects.WithLabelValues("test-nodes")), want)
+		}
+	}
+	sampled(3)
+
+	// Later samples follow the cache
+	if err := nodes.Delete(testNode("node-3", nil)); err != nil {
+		t.Fatal(err)
+	}
+	sampled(2)
+}
+
+func TestServeHTTPPortInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := serveHTTP(discardLogger(), "metrics", taken.Addr().String(), http.NotFoundHandler(), stopCh); err == nil {
+		t.Error("serveHTTP() on an address in use succeeded, want an error")
+	}
+	if err := serveHTTP(discardLogger(), "metrics", "127.0.0.1:0", http.NotFoundHandler(), stopCh); err != nil {
+		t.Errorf("serveHTTP() on a free address failed: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Severities of a RebootEvent, in increasing order
+const (
+	severityInfo    = "info"
+	severityWarning = "warning"
+)
+
+var severityRank = map[string]int{severityInfo: 0, severityWarning: 1}
+
+// RebootEvent is a reboot lifecycle transition sent to a Notifier. Node is set for Events on a
+// node; Namespace and Pod for those on a pod, such as a skipped workload restart.
+type RebootEvent struct {
+	Node      string    `json:"node"`
+	Namespace string    `json:"namespace,omitempty"`
+	Pod       string    `json:"pod,omitempty"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Severity  string    `json:"severity"`
+	Time      time.Time `json:"time"`
+}
+
+// Notifier sends reboot events somewhere outside the cluster
+type Notifier interface {
+	Notify(ctx context.Context, event RebootEvent) error
+}
+
+// webhookNotifier POSTs each event as JSON to a URL, e.g. a Slack incoming webhook relay
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string, timeout time.Duration) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Notify sends the event, retrying once if the request fails or gets a non-2xx response
+func (n *webhookNotifier) Notify(ctx context.Context, event RebootEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	if err = n.post(ctx, payload); err == nil {
+		return nil
+	}
+	return n.post(ctx, payload)
+}
+
+// Helper function to make a single POST of the payload
+func (n *webhookNotifier) post(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyingRecorder passes Events on to the wrapped recorder and also sends those at or above
+// minSeverity to a Notifier, so notifications go out at the same transitions as Events.
+type notifyingRecorder struct {
+	record.EventRecorder
+	logger      *slog.Logger
+	notifier    Notifier
+	minSeverity string
+	timeout     time.Duration
+}
+
+func (r *notifyingRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, message)
+	r.notify(object, eventtype, reason, message)
+}
+
+func (r *notifyingRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	r.notify(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *notifyingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	r.notify(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// Helper function to send a notification in the background, so a slow webhook doesn't hold up
+// the worker. Failures are only logged.
+func (r *notifyingRecorder) notify(object runtime.Object, eventtype, reason, message string) {
+	severity := severityInfo
+	if eventtype == v1.EventTypeWarning {
+		severity = severityWarning
+	}
+	if severityRank[severity] < severityRank[r.minSeverity] {
+		return
+	}
+	event := RebootEvent{Reason: reason, Message: message, Severity: severity, Time: time.Now().UTC()}
+	switch object := object.(type) {
+	case *v1.Node:
+		event.Node = object.Name
+	case *v1.Pod:
+		event.Namespace, event.Pod = object.Namespace, object.Name
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+		if err := r.notifier.Notify(ctx, event); err != nil {
+			r.logger.Warn("Failed to send notification", "node", event.Node, "namespace", event.Namespace, "pod", event.Pod, "reason", reason, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Helper function to start a webhook receiver that answers with the given status codes in turn
+// (200 once they run out), delivering each payload on the returned channel
+func testWebhook(t *testing.T, statuses ...int) (*httptest.Server, chan RebootEvent) {
+	t.Helper()
+	events := make(chan RebootEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event RebootEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid webhook payload: %v", err)
+		}
+		events <- event
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, events
+}
+
+func TestWebhookNotifiesCompletedReboot(t *testing.T) {
+	server, events := testWebhook(t)
+	recorder := &notifyingRecorder{
+		EventRecorder: record.NewFakeRecorder(10),
+		logger:        discardLogger(),
+		notifier:      newWebhookNotifier(server.URL, time.Second),
+		minSeverity:   severityInfo,
+		timeout:       time.Second,
+	}
+	recorder.Eventf(testNode("node-1", nil), v1.EventTypeNormal, eventRebootCompleted, "Reboot %s completed", "abc")
+
+	select {
+	case event := <-events:
+		if event.Node != "node-1" || event.Reason != eventRebootCompleted || event.Message != "Reboot abc completed" || event.Severity != severityInfo || event.Time.IsZero() {
+			t.Errorf("payload = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook never called")
+	}
+}
+
+func TestWebhookRetriesOnce(t *testing.T) {
+	server, events := testWebhook(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	notifier := newWebhookNotifier(server.URL, time.Second)
+
+	if err := notifier.Notify(context.Background(), RebootEvent{Node: "node-1"}); err == nil {
+		t.Error("Notify() succeeded against a failing webhook")
+	}
+	if len(events) != 2 {
+		t.Errorf("webhook called %d times, want 2", len(events))
+	}
+
+	// A single failure is retried successfully
+	<-events
+	<-events
+	if err := notifier.Notify(context.Background(), RebootEvent{Node: "node-1"}); err != nil {
+		t.Errorf("Notify() failed after a retry: %v", err)
+	}
+}
+
+func TestNotifyMinSeverity(t *testing.T) {
+	server, events := testWebhook(t)
+	recorder := &notifyingRecorder{
+		EventRecorder: record.NewFakeRecorder(10),
+		logger:        discardLogger(),
+		notifier:      newWebhookNotifier(server.URL, time.Second),
+		minSeverity:   severityWarning,
+		timeout:       time.Second,
+	}
+	recorder.Eventf(testNode("node-1", nil), v1.EventTypeNormal, eventRebootCompleted, "Reboot completed")
+	recorder.Eventf(testNode("node-1", nil), v1.EventTypeWarning, eventRebootFailed, "Failed to drain node")
+
+	select {
+	case event := <-events:
+		if event.Reason != eventRebootFailed || event.Severity != severityWarning {
+			t.Errorf("notified %+v, want only the warning", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook never called")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("notified %+v below the minimum severity", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotifyIdentifiesObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		object runtime.Object
+		want   RebootEvent
+	}{
+		{"node", testNode("node-1", nil), RebootEvent{Node: "node-1"}},
+		{"pod", testPod("team-a", "web-1", "node-1", "ReplicaSet"), RebootEvent{Namespace: "team-a", Pod: "web-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, events := testWebhook(t)
+			recorder := &notifyingRecorder{
+				EventRecorder: record.NewFakeRecorder(10),
+				logger:        discardLogger(),
+				notifier:      newWebhookNotifier(server.URL, time.Second),
+				minSeverity:   severityInfo,
+				timeout:       time.Second,
+			}
+			recorder.Event(tt.object, v1.EventTypeNormal, eventRestartSkipped, "Restart skipped")
+
+			select {
+			case event := <-events:
+				if event.Node != tt.want.Node || event.Namespace != tt.want.Namespace || event.Pod != tt.want.Pod {
+					t.Errorf("payload node %q, namespace %q, pod %q, want %q, %q, %q", event.Node, event.Namespace, event.Pod, tt.want.Node, tt.want.Namespace, tt.want.Pod)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("webhook never called")
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Workload kinds triggerRolloutRestart knows how to restart, besides the rollout CRs in
+// rolloutRestarts. Owner traversal stops at the first of these.
+var restartableKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "Deployment"}:  true,
+	{Group: "apps", Kind: "StatefulSet"}: true,
+	{Group: "apps", Kind: "DaemonSet"}:   true,
+	{Group: "batch", Kind: "Job"}:        true,
+}
+
+// resolveTopOwner follows controller owner references up from ref until it reaches a kind
+// the agent can restart, or an object with no controller. At most maxDepth owners are looked
+// up, and an owner met twice is an error, so a broken chain can't loop forever. Owners are
+// read through the dynamic client, their resource guessed from the kind.
+func resolveTopOwner(ctx context.Context, dynamicClient dynamic.Interface, namespace string, ref metav1.OwnerReference, maxDepth int, apiTimeout time.Duration) (metav1.OwnerReference, error) {
+	seen := map[string]bool{}
+	for depth := 0; ; depth++ {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			return ref, fmt.Errorf("failed to parse apiVersion of %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		gk := gv.WithKind(ref.Kind).GroupKind()
+		if _, rollout := rolloutRestarts[gk]; rollout || restartableKinds[gk] {
+			return ref, nil
+		}
+
+		id := gk.String() + "/" + ref.Name
+		if seen[id] {
+			return ref, fmt.Errorf("owner reference cycle at %s %s", ref.Kind, ref.Name)
+		}
+		seen[id] = true
+		if depth >= maxDepth {
+			return ref, fmt.Errorf("no restartable owner within %d levels, stopped at %s %s", maxDepth, ref.Kind, ref.Name)
+		}
+
+		resource, _ := meta.UnsafeGuessKindToResource(gv.WithKind(ref.Kind))
+		getCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+		owner, err := dynamicClient.Resource(resource).Namespace(namespace).Get(getCtx, ref.Name, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return ref, fmt.Errorf("failed to get %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		controller := metav1.GetControllerOf(owner)
+		if controller == nil {
+			return ref, nil // The root, left to triggerRolloutRestart to make sense of
+		}
+		ref = *controller
+	}
+}
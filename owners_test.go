@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/utils/ptr"
+)
+
+// Helper function to build a controller reference to an example.com Widget
+func widgetRef(name string) metav1.OwnerReference {
+	return metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Widget", Name: name, Controller: ptr.To(true)}
+}
+
+// Helper function to build a Widget controlled by owner, or by nothing if owner is nil
+func testWidget(name string, owner *metav1.OwnerReference) *unstructured.Unstructured {
+	widget := &unstructured.Unstructured{}
+	widget.SetAPIVersion("example.com/v1")
+	widget.SetKind("Widget")
+	widget.SetNamespace("default")
+	widget.SetName(name)
+	if owner != nil {
+		widget.SetOwnerReferences([]metav1.OwnerReference{*owner})
+	}
+	return widget
+}
+
+func TestResolveTopOwner(t *testing.T) {
+	deployment := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: ptr.To(true)}
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		maxDepth int
+		want     string // Name of the resolved owner
+		wantErr  string
+	}{
+		{
+			name:     "three levels",
+			objects:  []runtime.Object{testWidget("a", ptr.To(widgetRef("b"))), testWidget("b", ptr.To(widgetRef("c"))), testWidget("c", &deployment)},
+			maxDepth: 5,
+			want:     "web",
+		},
+		{
+			name:     "root without a controller",
+			objects:  []runtime.Object{testWidget("a", ptr.To(widgetRef("b"))), testWidget("b", nil)},
+			maxDepth: 5,
+			want:     "b",
+		},
+		{
+			name:     "cycle",
+			objects:  []runtime.Object{testWidget("a", ptr.To(widgetRef("b"))), testWidget("b", ptr.To(widgetRef("a")))},
+			maxDepth: 5,
+			wantErr:  "cycle",
+		},
+		{
+			name:     "deeper than the limit",
+			objects:  []runtime.Object{testWidget("a", ptr.To(widgetRef("b"))), testWidget("b", ptr.To(widgetRef("c"))), testWidget("c", &deployment)},
+			maxDepth: 2,
+			wantErr:  "within 2 levels",
+		},
+		{
+			name:     "missing owner",
+			objects:  []runtime.Object{testWidget("a", ptr.To(widgetRef("gone")))},
+			maxDepth: 5,
+			wantErr:  "failed to get Widget gone",
+		},
+	}
+	for _, tt := range tests {
+		client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), tt.objects...)
+		got, err := resolveTopOwner(context.Background(), client, "default", widgetRef("a"), tt.maxDepth, time.Second)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: resolveTopOwner() error = %v, want one mentioning %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: resolveTopOwner() failed: %v", tt.name, err)
+			continue
+		}
+		if got.Name != tt.want {
+			t.Errorf("%s: resolved %s %s, want %s", tt.name, got.Kind, got.Name, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// partialDrainResult is the response of /drain: the pods evicted from the node, or that would
+// be in dry-run mode
+type partialDrainResult struct {
+	Node     string   `json:"node"`
+	Selector string   `json:"selector"`
+	Pods     []string `json:"pods"`
+	DryRun   bool     `json:"dryRun,omitempty"`
+}
+
+// partialDrain evicts the pods on a node matching selector, the way a drain evicts them, and
+// waits until they are gone. The node is neither cordoned nor rebooted, so the evicted pods
+// may be scheduled back onto it. Pods a drain leaves in place, and those in namespaces the
+// drain filter leaves out, are never evicted. The caller holds a slot from the drain limiter.
+// Returns the namespace/name of the pods evicted.
+func (c *Controller) partialDrain(ctx context.Context, nodeName string, selector labels.Selector) ([]string, error) {
+	matched := map[string]bool{}
+	selected := func(pods []v1.Pod) ([]*v1.Pod, error) {
+		var selected []*v1.Pod
+		for i := range pods {
+			pod := &pods[i]
+			if evictable(pod) && c.drainFilter.allows(pod.Namespace) && selector.Matches(labels.Set(pod.Labels)) {
+				matched[pod.Namespace+"/"+pod.Name] = true
+				selected = append(selected, pod)
+			}
+		}
+		return selected, nil
+	}
+
+	if c.dryRun {
+		pods, err := listNodePods(ctx, c.clientset, nodeName, c.apiTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+		}
+		selected(pods.Items)
+	} else {
+		version, err := c.evictions.policyVersion()
+		if err != nil {
+			return nil, fmt.Errorf("failed to drain pods from node %s: %w", nodeName, err)
+		}
+		drainsInProgress.Inc()
+		err = evictUntilGone(ctx, c.clientset, version, nodeName, c.apiTimeout, selected)
+		drainsInProgress.Dec()
+		if err != nil {
+			return nil, fmt.Errorf("failed to drain pods from node %s: %w", nodeName, err)
+		}
+	}
+
+	evicted := make([]string, 0, len(matched))
+	for pod := range matched {
+		evicted = append(evicted, pod)
+	}
+	sort.Strings(evicted)
+	return evicted, nil
+}
+
+// partialDrainHandler serves POST /drain?node=<name>&selector=<label selector>, evicting only
+// the node's pods matching the selector through partialDrain, for targeted remediation without
+// a reboot. The drain gives up after defaultTimeout unless &timeout= sets another duration.
+// Only nodes the agent watches can be drained, and the selector must not be empty, as that
+// would evict everything without cordoning the node.
+func (c *Controller) partialDrainHandler(defaultTimeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		nodeName := query.Get("node")
+		if nodeName == "" {
+			http.Error(w, "missing node", http.StatusBadRequest)
+			return
+		}
+		selector, err := labels.Parse(query.Get("selector"))
+		if err != nil || selector.Empty() {
+			http.Error(w, fmt.Sprintf("invalid or empty selector %q", query.Get("selector")), http.StatusBadRequest)
+			return
+		}
+		timeout := defaultTimeout
+		if value := query.Get("timeout"); value != "" {
+			if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+				http.Error(w, fmt.Sprintf("invalid timeout %q", value), http.StatusBadRequest)
+				return
+			}
+		}
+		node, err := c.nodeLister.Get(nodeName)
+		if apierrors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("node %s is not watched by the agent", nodeName), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logger := c.logger.With("node", nodeName, "selector", selector.String())
+		// Like a full drain, the timeout starts once a drain slot is free
+		release, err := c.drainLimiter.acquire(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("waiting for a drain slot: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		logger.Info("Draining pods matching selector", "timeout", timeout)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		evicted, err := c.partialDrain(ctx, nodeName, selector)
+		if err != nil {
+			logger.Error("Failed to drain pods matching selector", "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, context.DeadlineExceeded) {
+				status = http.StatusGatewayTimeout
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		logger.Info("Drained pods matching selector", "pods", evicted)
+		if !c.dryRun {
+			c.recorder.Eventf(node, v1.EventTypeNormal, eventPartialDrain, "Evicted %d pods matching %s", len(evicted), selector)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(partialDrainResult{Node: nodeName, Selector: selector.String(), Pods: evicted, DryRun: c.dryRun})
+	})
+}
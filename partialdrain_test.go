@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Helper function to build a pod bound to node-1 with the given app label
+func testAppPod(namespace, name, app string) *v1.Pod {
+	pod := testPod(namespace, name, "node-1", "ReplicaSet")
+	pod.Labels = map[string]string{"app": app}
+	return pod
+}
+
+func TestPartialDrain(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		evicted []string
+	}{
+		{"matching pods", "?node=node-1&selector=app%3Dweb", http.StatusOK, []string{"default/web-1", "default/web-2"}},
+		{"set selector", "?node=node-1&selector=app+in+(web,db)", http.StatusOK, []string{"default/db-1", "default/web-1", "default/web-2"}},
+		{"no match", "?node=node-1&selector=app%3Dcache", http.StatusOK, nil},
+		{"empty selector", "?node=node-1&selector=", http.StatusBadRequest, nil},
+		{"invalid selector", "?node=node-1&selector=app%3D%3D%3D", http.StatusBadRequest, nil},
+		{"missing node", "?selector=app%3Dweb", http.StatusBadRequest, nil},
+		{"unwatched node", "?node=node-2&selector=app%3Dweb", http.StatusNotFound, nil},
+		{"invalid timeout", "?node=node-1&selector=app%3Dweb&timeout=soon", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := testPod("kube-system", "fluentd", "node-1", "DaemonSet")
+			daemon.Labels = map[string]string{"app": "web"}
+			c, client := newTestController(t, testNode("node-1", nil),
+				testAppPod("default", "web-1", "web"),
+				testAppPod("default", "web-2", "web"),
+				testAppPod("default", "db-1", "db"),
+				daemon,
+			)
+			evicted := reactToEvictions(t, client, nil)
+
+			recorder := httptest.NewRecorder()
+			c.partialDrainHandler(time.Second).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/drain"+tt.query, nil))
+			if recorder.Code != tt.status {
+				t.Fatalf("POST /drain%s = %d, want %d: %s", tt.query, recorder.Code, tt.status, recorder.Body.String())
+			}
+			sort.Strings(*evicted)
+			if !slices.Equal(*evicted, tt.evicted) {
+				t.Errorf("evicted %v, want %v", *evicted, tt.evicted)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var result partialDrainResult
+			if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(result.Pods, tt.evicted) {
+				t.Errorf("response lists %v, want %v", result.Pods, tt.evicted)
+			}
+			// A partial drain never cordons the node
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "patch" || action.GetVerb() == "update" {
+					t.Errorf("unexpected %s of %s", action.GetVerb(), action.GetResource().Resource)
+				}
+			}
+		})
+	}
+}
+
+func TestPartialDrainMethod(t *testing.T) {
+	c, _ := newTestController(t, testNode("node-1", nil))
+	recorder := httptest.NewRecorder()
+	c.partialDrainHandler(time.Second).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/drain?node=node-1&selector=app%3Dweb", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /drain = %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RebootRequestPayload is the JSON form of the reboot annotation value, for requests that carry
+// more than a yes/no, e.g. {"reason":"KernelUpdate","detail":"6.8.0-45","priority":5}
+type RebootRequestPayload struct {
+	// Why the reboot was requested, one of the reason codes, surfaced in logs, events and metrics
+	Reason string `json:"reason,omitempty"`
+	// Free text going with the reason
+	Detail string `json:"detail,omitempty"`
+	// Nodes waiting in the queue with higher priorities are handled first, the default is 0
+	Priority int `json:"priority,omitempty"`
+}
+
+// parseRebootAnnotation parses a reboot annotation value: a JSON payload if it starts with {,
+// otherwise a flag as accepted by parseRebootFlag. Returns nil if no reboot is requested.
+func parseRebootAnnotation(value string) (*RebootRequestPayload, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "{") {
+		reboot, err := parseRebootFlag(value)
+		if err != nil || !reboot {
+			return nil, err
+		}
+		return &RebootRequestPayload{}, nil
+	}
+
+	// Unknown fields are rejected so a typo doesn't silently lose the priority or reason
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	var payload RebootRequestPayload
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload %q: %w", value, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON payload %q: unexpected data after the object", value)
+	}
+	return &payload, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseRebootFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", true, false}, // The annotation used to be a bare marker
+		{"true", true, false},
+		{" Yes ", true, false},
+		{"1", true, false},
+		{"false", false, false},
+		{"NO", false, false},
+		{"0", false, false},
+		{"soon", false, true},
+		{"2", false, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRebootFlag(tt.value)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseRebootFlag(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRebootFlagValues(t *testing.T) {
+	keys := testKeys(t)
+	for _, tt := range []struct {
+		value string
+		want  bool
+	}{{"", true}, {"false", false}, {"garbage", false}} {
+		// Nodes
+		node := testNode("node-1", map[string]string{keys.Reboot: tt.value})
+		if got := shouldReboot(discardLogger(), node, keys, nil, time.Now(), newRebootLimiter(1)).reboot; got != tt.want {
+			t.Errorf("node with reboot=%q: reboot = %v, want %v", tt.value, got, tt.want)
+		}
+
+		// Pods
+		pod := testPod("default", "db-0", "node-1", "StatefulSet")
+		pod.Annotations = map[string]string{keys.Reboot: tt.value}
+		client := fake.NewSimpleClientset(&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0-owner"}})
+		err := newTestRestarter(t, client, nil, record.NewFakeRecorder(10), newRestartCooldown(0)).handlePodAnnotations(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("handlePodAnnotations() failed: %v", err)
+		}
+		if restarted := len(client.Actions()) > 0; restarted != tt.want {
+			t.Errorf("pod with reboot=%q: restarted = %v, want %v", tt.value, restarted, tt.want)
+		}
+	}
+}
+
+func TestParseRebootAnnotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    *RebootRequestPayload
+		wantErr bool
+	}{
+		{"payload", `{"reason":"kernel-update","priority":5}`, &RebootRequestPayload{Reason: "kernel-update", Priority: 5}, false},
+		{"detail", `{"reason":"kernel-update","detail":"6.8.0-45"}`, &RebootRequestPayload{Reason: "kernel-update", Detail: "6.8.0-45"}, false},
+		{"empty payload", ` {} `, &RebootRequestPayload{}, false},
+		{"bare marker", "", &RebootRequestPayload{}, false},
+		{"bare true", "true", &RebootRequestPayload{}, false},
+		{"bare false", "false", nil, false},
+		{"malformed JSON", `{"reason":"kernel-update"`, nil, true},
+		{"wrong type", `{"priority":"high"}`, nil, true},
+		{"unknown field", `{"reason":"kernel-update","priorty":5}`, nil, true},
+		{"trailing data", `{"priority":5}{}`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRebootAnnotation(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseRebootAnnotation(%q) error = %v, want error %v", tt.name, tt.value, err, tt.wantErr)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: parseRebootAnnotation(%q) = %+v, want %+v", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
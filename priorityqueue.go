@@ -0,0 +1,118 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// nodePriorityQueue orders the node queue so that, when several nodes are waiting, higher
+// reboot priorities are handled first, then the nodes that requested a reboot earliest. It is
+// plugged into the workqueue as its underlying Queue, which serializes access to it.
+type nodePriorityQueue struct {
+	// Looks up the node's reboot priority and whether it requests a reboot at all
+	lookup func(nodeName string) (priority int, requested bool)
+
+	items []*queuedNode
+	seq   uint64
+	// When each node requesting a reboot was first queued with the request, kept across
+	// requeues so a node waiting for a slot doesn't lose its place
+	requestedSince map[string]time.Time
+}
+
+type queuedNode struct {
+	name           string
+	priority       int
+	requestedSince time.Time
+	seq            uint64
+	index          int
+}
+
+var _ workqueue.Queue[string] = &nodePriorityQueue{}
+
+func newNodePriorityQueue(lookup func(nodeName string) (int, bool)) *nodePriorityQueue {
+	return &nodePriorityQueue{lookup: lookup, requestedSince: make(map[string]time.Time)}
+}
+
+func (q *nodePriorityQueue) Push(name string) {
+	item := &queuedNode{name: name, seq: q.seq}
+	q.seq++
+	q.rank(item)
+	heap.Push((*nodeHeap)(q), item)
+}
+
+// Touch re-ranks a queued node that was added again, its annotations may have changed
+func (q *nodePriorityQueue) Touch(name string) {
+	for _, item := range q.items {
+		if item.name == name {
+			q.rank(item)
+			heap.Fix((*nodeHeap)(q), item.index)
+			return
+		}
+	}
+}
+
+func (q *nodePriorityQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *nodePriorityQueue) Pop() string {
+	return heap.Pop((*nodeHeap)(q)).(*queuedNode).name
+}
+
+// Helper function to refresh a queued node's priority and request time
+func (q *nodePriorityQueue) rank(item *queuedNode) {
+	priority, requested := q.lookup(item.name)
+	if !requested {
+		delete(q.requestedSince, item.name)
+		item.priority, item.requestedSince = priority, time.Time{}
+		return
+	}
+	since, ok := q.requestedSince[item.name]
+	if !ok {
+		since = time.Now()
+		q.requestedSince[item.name] = since
+	}
+	item.priority, item.requestedSince = priority, since
+}
+
+// nodeHeap implements heap.Interface over the queue's items
+type nodeHeap nodePriorityQueue
+
+func (h *nodeHeap) Len() int {
+	return len(h.items)
+}
+
+// Higher priority first, then earlier request; nodes without a request have a zero request
+// time so they go first among equal priorities, they only need their state tidied up
+func (h *nodeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if !a.requestedSince.Equal(b.requestedSince) {
+		return a.requestedSince.Before(b.requestedSince)
+	}
+	return a.seq < b.seq
+}
+
+func (h *nodeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index = i
+	h.items[j].index = j
+}
+
+func (h *nodeHeap) Push(x interface{}) {
+	item := x.(*queuedNode)
+	item.index = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *nodeHeap) Pop() interface{} {
+	last := len(h.items) - 1
+	item := h.items[last]
+	h.items[last] = nil
+	h.items = h.items[:last]
+	return item
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestNodeQueuePriorityOrder(t *testing.T) {
+	keys := testKeys(t)
+	c, _ := newTestController(t,
+		testNode("node-low", map[string]string{keys.Reboot: `{"priority":1}`}),
+		testNode("node-high", map[string]string{keys.Reboot: `{"priority":5}`}),
+		testNode("node-mid", map[string]string{keys.Reboot: `{"priority":3}`}),
+	)
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		return c.nodeQueue.Len() == 3, nil
+	})
+	if err != nil {
+		t.Fatalf("%d nodes queued, want 3", c.nodeQueue.Len())
+	}
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		key, _ := c.nodeQueue.Get()
+		order = append(order, key)
+		c.nodeQueue.Done(key)
+	}
+	if want := []string{"node-high", "node-mid", "node-low"}; !slices.Equal(order, want) {
+		t.Errorf("nodes handled in order %v, want %v", order, want)
+	}
+}
+
+func TestNodePriorityQueueTiesAndTouch(t *testing.T) {
+	priorities := map[string]int{"a": 2, "b": 2, "c": 2, "idle": 2}
+	lookup := func(name string) (int, bool) {
+		priority, ok := priorities[name]
+		return priority, ok && name != "idle"
+	}
+	q := newNodePriorityQueue(lookup)
+	for _, name := range []string{"a", "b", "c"} {
+		q.Push(name)
+		time.Sleep(time.Millisecond) // Distinct request times
+	}
+	q.Push("idle")
+
+	// Raising c's priority re-ranks it when it is added again
+	priorities["c"] = 9
+	q.Touch("c")
+
+	var order []string
+	for q.Len() > 0 {
+		order = append(order, q.Pop())
+	}
+	// Among equal priorities a node without a request goes first, then the earliest request
+	if want := []string{"c", "idle", "a", "b"}; !slices.Equal(order, want) {
+		t.Errorf("popped %v, want %v", order, want)
+	}
+
+	// A node keeps its request time across requeues
+	q.Push("b")
+	q.Push("a")
+	if got := []string{q.Pop(), q.Pop()}; !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("requeued nodes popped as %v, want a then b by original request time", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Reason codes for a reboot, used in events, the reason metric label and the reboot-reason
+// annotation. A reason given as free text is matched against them ignoring case, dashes,
+// underscores and spaces, so kernel-update is KernelUpdate; anything else is Other.
+const (
+	ReasonKernelUpdate         = "KernelUpdate"
+	ReasonSecurityPatch        = "SecurityPatch"
+	ReasonManualRequest        = "ManualRequest"
+	ReasonCrashLoopRemediation = "CrashLoopRemediation"
+	ReasonOther                = "Other"
+)
+
+var reasonCodes = []string{ReasonKernelUpdate, ReasonSecurityPatch, ReasonManualRequest, ReasonCrashLoopRemediation, ReasonOther}
+
+// RebootReason is why a node is rebooting: one of the reason codes, and free text with any
+// detail, e.g. the kernel version being installed
+type RebootReason struct {
+	Code   string
+	Detail string
+}
+
+func (r RebootReason) String() string {
+	if r.Detail == "" {
+		return r.Code
+	}
+	return r.Code + ", detail: " + r.Detail
+}
+
+// Helper function to reduce a reason to its comparable form: lower case, without dashes,
+// underscores or spaces
+func reasonMatchKey(reason string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(reason))
+}
+
+// newRebootReason maps a given reason to its code. A reason that isn't a known code becomes
+// Other, keeping its text as the detail unless a detail was given.
+func newRebootReason(reason, detail string) RebootReason {
+	reason, detail = strings.TrimSpace(reason), strings.TrimSpace(detail)
+	if reason == "" {
+		return RebootReason{Code: ReasonOther, Detail: detail}
+	}
+	for _, code := range reasonCodes {
+		if reasonMatchKey(reason) == reasonMatchKey(code) {
+			return RebootReason{Code: code, Detail: detail}
+		}
+	}
+	if detail == "" {
+		detail = reason
+	}
+	return RebootReason{Code: ReasonOther, Detail: detail}
+}
+
+// Helper function to get why the node is rebooting: the reason in the reboot payload, else the
+// reboot-reason annotations, else Other
+func rebootReason(node *v1.Node, keys AnnotationKeys) RebootReason {
+	// An invalid reboot annotation is logged by shouldReboot
+	if payload, err := parseRebootAnnotation(node.Annotations[keys.Reboot]); err == nil && payload != nil && (payload.Reason != "" || payload.Detail != "") {
+		return newRebootReason(payload.Reason, payload.Detail)
+	}
+	return newRebootReason(node.Annotations[keys.RebootReason], node.Annotations[keys.RebootReasonDetail])
+}
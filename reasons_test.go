@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewRebootReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		detail string
+		want   RebootReason
+	}{
+		{"code", "KernelUpdate", "", RebootReason{Code: ReasonKernelUpdate}},
+		{"dashes", "kernel-update", "", RebootReason{Code: ReasonKernelUpdate}},
+		{"underscores", "SECURITY_PATCH", "", RebootReason{Code: ReasonSecurityPatch}},
+		{"spaces", "crash loop remediation", "", RebootReason{Code: ReasonCrashLoopRemediation}},
+		{"with detail", "manual-request", "disk swap", RebootReason{Code: ReasonManualRequest, Detail: "disk swap"}},
+		{"unknown", "patch tuesday", "", RebootReason{Code: ReasonOther, Detail: "patch tuesday"}},
+		{"unknown with detail", "patch tuesday", "KB5031356", RebootReason{Code: ReasonOther, Detail: "KB5031356"}},
+		{"empty", "", "", RebootReason{Code: ReasonOther}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newRebootReason(tt.reason, tt.detail); got != tt.want {
+				t.Errorf("newRebootReason(%q, %q) = %+v, want %+v", tt.reason, tt.detail, got, tt.want)
+			}
+		})
+	}
+}
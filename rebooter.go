@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	v1 "k8s.io/api/core/v1"
+)
+
+// How long to wait for the node to drop the SSH connection after the reboot command was issued
+const sshDisconnectTimeout = 2 * time.Minute
+
+// Command run over SSH by SSHRebooter.Verify, harmless on any node
+const sshVerifyCommand = "true"
+
+// Rebooter reboots a node once it has been drained and marked reboot-in-progress. Verify checks
+// the rebooter could reach and command the node, without rebooting it.
+type Rebooter interface {
+	Reboot(ctx context.Context, node *v1.Node) error
+	Verify(ctx context.Context, node *v1.Node) error
+}
+
+// verifyBackends runs the rebooter's Verify against each node in turn, each within timeout,
+// logging the outcome. Returns the number of nodes that failed.
+func verifyBackends(ctx context.Context, logger *slog.Logger, rebooter Rebooter, nodes []*v1.Node, timeout time.Duration) int {
+	failed := 0
+	for _, node := range nodes {
+		verifyCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := rebooter.Verify(verifyCtx, node)
+		cancel()
+		if err != nil {
+			failed++
+			logger.Error("Reboot backend can't reach the node", "node", node.Name, "error", err)
+			continue
+		}
+		logger.Info("Reboot backend verified", "node", node.Name)
+	}
+	return failed
+}
+
+// noopRebooter leaves the reboot to something else on the node watching the reboot-in-progress
+// annotation. It is used unless --ssh-key-path is set.
+type noopRebooter struct {
+	logger *slog.Logger
+}
+
+func (r noopRebooter) Reboot(ctx context.Context, node *v1.Node) error {
+	r.logger.Debug("No rebooter configured, leaving the reboot to the node", "node", node.Name)
+	return nil
+}
+
+// Verify has nothing to check, the node reboots itself
+func (r noopRebooter) Verify(ctx context.Context, node *v1.Node) error {
+	return nil
+}
+
+// SSHRebooter runs a reboot command on the node over SSH, authenticating with a private key and
+// checking the node's host key against a known_hosts file
+type SSHRebooter struct {
+	config      *ssh.ClientConfig
+	port        int
+	command     string
+	dialTimeout time.Duration
+}
+
+func NewSSHRebooter(user, keyPath, knownHostsPath string, port int, command string, dialTimeout time.Duration) (*SSHRebooter, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key %s: %w", keyPath, err)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SSH known hosts: %w", err)
+	}
+	return &SSHRebooter{
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         dialTimeout,
+		},
+		port:        port,
+		command:     command,
+		dialTimeout: dialTimeout,
+	}, nil
+}
+
+// Reboot connects to the node's internal IP and runs the reboot command. It only succeeds once
+// the node drops the connection, so a command that exits without rebooting is an error.
+func (r *SSHRebooter) Reboot(ctx context.Context, node *v1.Node) error {
+	client, addr, err := r.connect(ctx, node)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session on %s: %w", addr, err)
+	}
+	if err := session.Start(r.command); err != nil {
+		return fmt.Errorf("failed to run %q on %s: %w", r.command, addr, err)
+	}
+
+	disconnected := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(disconnected)
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- session.Wait()
+	}()
+
+	timer := time.NewTimer(sshDisconnectTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-disconnected:
+			return nil
+		case err := <-exited:
+			// The connection dropping before the command exits is the expected outcome. A command
+			// that exits cleanly (e.g. systemctl reboot) leaves us waiting for the drop.
+			var exitErr *ssh.ExitError
+			if errors.As(err, &exitErr) {
+				return fmt.Errorf("%q failed on %s: %w", r.command, addr, err)
+			}
+			exited = nil
+		case <-timer.C:
+			return fmt.Errorf("%s did not drop the SSH connection within %s of %q", addr, sshDisconnectTimeout, r.command)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Verify connects to the node as Reboot would and runs `true`, checking the host key, the
+// credentials and that commands run
+func (r *SSHRebooter) Verify(ctx context.Context, node *v1.Node) error {
+	client, addr, err := r.connect(ctx, node)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session on %s: %w", addr, err)
+	}
+	defer session.Close()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- session.Run(sshVerifyCommand)
+	}()
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("%q failed on %s: %w", sshVerifyCommand, addr, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Helper function to open an SSH connection to the node's internal IP, returning the client
+// and the address it connected to
+func (r *SSHRebooter) connect(ctx context.Context, node *v1.Node) (*ssh.Client, string, error) {
+	ip := nodeInternalIP(node)
+	if ip == "" {
+		return nil, "", fmt.Errorf("node %s has no internal IP", node.Name)
+	}
+	addr := net.JoinHostPort(ip, strconv.Itoa(r.port))
+
+	dialer := net.Dialer{Timeout: r.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, r.config)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("failed to open SSH connection to %s: %w", addr, err)
+	}
+	return ssh.NewClient(sshConn, chans, reqs), addr, nil
+}
+
+// Helper function to find the node's internal IP in its status
+func nodeInternalIP(node *v1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
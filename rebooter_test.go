@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	v1 "k8s.io/api/core/v1"
+)
+
+// testSSHServer accepts one client key and reports each command it is asked to run on
+// commands. It then drops the connection, as a rebooting node would, or with exitStatus set
+// answers with that exit status instead. The verify command always succeeds.
+type testSSHServer struct {
+	listener   net.Listener
+	commands   chan string
+	exitStatus uint32
+}
+
+// Helper function to start an SSH server on a loopback port, returning the paths of a client
+// key it accepts and of a known_hosts file trusting it
+func startTestSSHServer(t *testing.T, exitStatus uint32) (server *testSSHServer, keyPath, knownHostsPath string) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublic, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "core" || string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server = &testSSHServer{listener: listener, commands: make(chan string, 1), exitStatus: exitStatus}
+	go server.serve(config)
+
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath = filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	knownHostsPath = filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return server, keyPath, knownHostsPath
+}
+
+func (s *testSSHServer) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn, config)
+	}
+}
+
+func (s *testSSHServer) handle(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		for req := range requests {
+			if req.Type != "exec" {
+				req.Reply(false, nil)
+				continue
+			}
+			var exec struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &exec); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			s.commands <- exec.Command
+			if exec.Command == sshVerifyCommand {
+				// Runs like on any live node
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+				continue
+			}
+			if s.exitStatus == 0 {
+				return // The node goes down, taking the connection with it
+			}
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{s.exitStatus}))
+			channel.Close()
+		}
+	}
+}
+
+// Helper function to build a node reachable on the test server's address
+func sshTestNode(t *testing.T, server *testSSHServer) (*v1.Node, int) {
+	t.Helper()
+	node := testNode("node-1", nil)
+	node.Status.Addresses = []v1.NodeAddress{
+		{Type: v1.NodeHostName, Address: "node-1"},
+		{Type: v1.NodeInternalIP, Address: "127.0.0.1"},
+	}
+	return node, server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestSSHRebooterRunsCommand(t *testing.T) {
+	server, keyPath, knownHostsPath := startTestSSHServer(t, 0)
+	node, port := sshTestNode(t, server)
+	rebooter, err := NewSSHRebooter("core", keyPath, knownHostsPath, port, "sudo systemctl reboot", 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewSSHRebooter() failed: %v", err)
+	}
+
+	if err := rebooter.Reboot(context.Background(), node); err != nil {
+		t.Fatalf("Reboot() failed: %v", err)
+	}
+	select {
+	case command := <-server.commands:
+		if command != "sudo systemctl reboot" {
+			t.Errorf("server ran %q, want the configured reboot command", command)
+		}
+	default:
+		t.Error("reboot command never reached the server")
+	}
+}
+
+func TestSSHRebooterCommandFails(t *testing.T) {
+	server, keyPath, knownHostsPath := startTestSSHServer(t, 1)
+	node, port := sshTestNode(t, server)
+	rebooter, err := NewSSHRebooter("core", keyPath, knownHostsPath, port, "sudo systemctl reboot", 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewSSHRebooter() failed: %v", err)
+	}
+
+	// The command exited without the node going down
+	if err := rebooter.Reboot(context.Background(), node); err == nil || !strings.Contains(err.Error(), "systemctl reboot") {
+		t.Errorf("Reboot() error = %v, want the failed command reported", err)
+	}
+}
+
+func TestSSHRebooterRejectsUnknownHost(t *testing.T) {
+	server, keyPath, _ := startTestSSHServer(t, 0)
+	node, port := sshTestNode(t, server)
+	// A known_hosts file trusting some other key for the server's address
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(otherPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.listener.Addr().String())}, otherKey)
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rebooter, err := NewSSHRebooter("core", keyPath, knownHostsPath, port, "sudo systemctl reboot", 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewSSHRebooter() failed: %v", err)
+	}
+	if err := rebooter.Reboot(context.Background(), node); err == nil {
+		t.Error("Reboot() succeeded against a host key missing from known_hosts")
+	}
+	if len(server.commands) != 0 {
+		t.Error("reboot command sent to an untrusted host")
+	}
+}
+
+func TestSSHRebooterVerify(t *testing.T) {
+	server, keyPath, knownHostsPath := startTestSSHServer(t, 0)
+	node, port := sshTestNode(t, server)
+	rebooter, err := NewSSHRebooter("core", keyPath, knownHostsPath, port, "sudo systemctl reboot", 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewSSHRebooter() failed: %v", err)
+	}
+
+	if err := rebooter.Verify(context.Background(), node); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	if command := <-server.commands; command != sshVerifyCommand {
+		t.Errorf("server ran %q, want %q", command, sshVerifyCommand)
+	}
+
+	unreachable := testNode("node-2", nil)
+	failed := verifyBackends(context.Background(), discardLogger(), rebooter, []*v1.Node{node, unreachable}, 5*time.Second)
+	if failed != 1 {
+		t.Errorf("verifyBackends() = %d failed, want 1 for the node without an address", failed)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// Phases of the reboot flow a RebootError can come from
+const (
+	phaseCordon   = "cordon"
+	phaseDrain    = "drain"
+	phaseStart    = "start"
+	phaseReboot   = "reboot"
+	phaseComplete = "complete"
+	phaseUncordon = "uncordon"
+
+	// Not a phase of the flow: counted when a node, pod or RebootRequest is dropped from the
+	// queue after --requeue-max-retries failures
+	phaseRetriesExhausted = "retries-exhausted"
+)
+
+// RebootError is returned by handleNodeAnnotations when a step of the reboot flow fails, so
+// callers can tell which node and phase failed without parsing messages
+type RebootError struct {
+	Node  string
+	Phase string
+	Err   error
+}
+
+func (e *RebootError) Error() string {
+	return fmt.Sprintf("reboot of node %s failed in %s phase: %v", e.Node, e.Phase, e.Err)
+}
+
+func (e *RebootError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRebootErrorPhases(t *testing.T) {
+	keys := testKeys(t)
+	errRefused := errors.New("connection refused")
+	errForbidden := errors.New("nodes is forbidden")
+
+	tests := []struct {
+		name  string
+		setup func(c *Controller, client k8stesting.FakeClient)
+		phase string
+		cause error
+	}{
+		{
+			name: "cordon",
+			setup: func(c *Controller, client k8stesting.FakeClient) {
+				client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errForbidden
+				})
+			},
+			phase: phaseCordon,
+			cause: errForbidden,
+		},
+		{
+			name: "reboot",
+			setup: func(c *Controller, client k8stesting.FakeClient) {
+				c.rebooter = failingRebooter{err: errRefused}
+			},
+			phase: phaseReboot,
+			cause: errRefused,
+		},
+	}
+	for _, tt := range tests {
+		c, client := newTestController(t, testNode("node-1", map[string]string{keys.Reboot: ""}))
+		tt.setup(c, client)
+
+		err := c.syncNode(context.Background(), "node-1")
+		var rebootErr *RebootError
+		if !errors.As(err, &rebootErr) {
+			t.Errorf("%s: syncNode() error = %v, want a *RebootError", tt.name, err)
+			continue
+		}
+		if rebootErr.Node != "node-1" || rebootErr.Phase != tt.phase {
+			t.Errorf("%s: RebootError for node %q in phase %q, want node-1 in %q", tt.name, rebootErr.Node, rebootErr.Phase, tt.phase)
+		}
+		if !errors.Is(err, tt.cause) {
+			t.Errorf("%s: syncNode() error = %v, want it to wrap %v", tt.name, err, tt.cause)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+)
+
+// How often a RebootRequest with unfinished nodes is re-evaluated against the node cache
+const rebootRequestPollInterval = 10 * time.Second
+
+// The RebootRequest CRD, see config/crd/rebootrequests.yaml
+var rebootRequestResource = schema.GroupVersionResource{Group: "reboot-agent.sdlt.local", Version: "v1alpha1", Resource: "rebootrequests"}
+
+// RebootRequest asks for a set of nodes to be rebooted. The agent turns it into the reboot
+// annotation on each node and reports each node's progress in the status.
+type RebootRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RebootRequestSpec   `json:"spec"`
+	Status RebootRequestStatus `json:"status,omitempty"`
+}
+
+type RebootRequestSpec struct {
+	NodeNames []string `json:"nodeNames"`
+}
+
+type RebootRequestStatus struct {
+	Nodes []NodeRebootStatus `json:"nodes,omitempty"`
+}
+
+type NodeRebootStatus struct {
+	Name  string      `json:"name"`
+	Phase RebootPhase `json:"phase"`
+	// When the reboot annotation was set; a reboot completed after this satisfies the request
+	RequestedAt *metav1.Time `json:"requestedAt,omitempty"`
+	Message     string       `json:"message,omitempty"`
+}
+
+type RebootPhase string
+
+const (
+	RebootPhasePending    RebootPhase = "Pending"
+	RebootPhaseInProgress RebootPhase = "InProgress"
+	RebootPhaseCompleted  RebootPhase = "Completed"
+	RebootPhaseFailed     RebootPhase = "Failed"
+)
+
+// Helper function to check whether a node's phase is final
+func rebootPhaseFinal(phase RebootPhase) bool {
+	return phase == RebootPhaseCompleted || phase == RebootPhaseFailed
+}
+
+// nodeFailure is the last failure of a node's reboot
+type nodeFailure struct {
+	message string
+	at      time.Time
+}
+
+// Helper function to note that a node's reboot failed, for the RebootRequests waiting on it to
+// report. Failed phases are retried by the node flow, but a request counts the node as failed.
+func (c *Controller) recordNodeFailure(nodeName, message string) {
+	c.nodeFailuresMu.Lock()
+	defer c.nodeFailuresMu.Unlock()
+	if c.nodeFailures == nil {
+		c.nodeFailures = map[string]nodeFailure{}
+	}
+	c.nodeFailures[nodeName] = nodeFailure{message: message, at: time.Now()}
+}
+
+// Helper function to get the message of a node's last reboot failure, if it failed at or after
+// the given time
+func (c *Controller) nodeFailedSince(nodeName string, since *metav1.Time) (string, bool) {
+	c.nodeFailuresMu.Lock()
+	defer c.nodeFailuresMu.Unlock()
+	failure, failed := c.nodeFailures[nodeName]
+	if !failed || since == nil || failure.at.Before(since.Time) {
+		return "", false
+	}
+	return failure.message, true
+}
+
+// EnableRebootRequests makes the controller process RebootRequests from the given informer,
+// which must watch rebootRequestResource. Call before Start.
+func (c *Controller) EnableRebootRequests(informer cache.SharedIndexInformer) error {
+	c.rebootRequestLister = dynamiclister.New(informer.GetIndexer(), rebootRequestResource)
+	c.rebootRequestQueue = workqueue.NewTypedRateLimitingQueueWithConfig(newRequeueRateLimiter(c.requeueBackoff),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "rebootrequests"})
+
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueue(c.rebootRequestQueue, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.enqueue(c.rebootRequestQueue, newObj)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add reboot request event handler: %w", err)
+	}
+	return nil
+}
+
+// Looks up the RebootRequest for a key, annotates its nodes and updates its status
+func (c *Controller) syncRebootRequest(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil // Malformed keys can never succeed, don't requeue
+	}
+	obj, err := c.rebootRequestLister.Namespace(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil // Deleted since it was queued
+	}
+	if err != nil {
+		return err
+	}
+
+	var request RebootRequest
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &request); err != nil {
+		return nil // Invalid objects can never succeed, don't requeue
+	}
+	logger := c.logger.With("rebootrequest", key)
+
+	// Once every node is Completed or Failed there's nothing left to do or to check back on
+	phases := map[string]RebootPhase{}
+	for _, nodeStatus := range request.Status.Nodes {
+		phases[nodeStatus.Name] = nodeStatus.Phase
+	}
+	finished := true
+	for _, nodeName := range request.Spec.NodeNames {
+		finished = finished && rebootPhaseFinal(phases[nodeName])
+	}
+	if finished {
+		return nil
+	}
+
+	status := RebootRequestStatus{}
+	finished = true
+	for _, nodeName := range request.Spec.NodeNames {
+		nodeStatus, err := c.reconcileRequestedNode(ctx, request.Status, request.CreationTimestamp, nodeName)
+		if err != nil {
+			return err
+		}
+		status.Nodes = append(status.Nodes, nodeStatus)
+		finished = finished && rebootPhaseFinal(nodeStatus.Phase)
+	}
+	// Node progress isn't visible on the RebootRequest itself, so check back until every node
+	// is done rather than waiting for a resync
+	if !finished {
+		c.rebootRequestQueue.AddAfter(key, rebootRequestPollInterval)
+	}
+	if equalRebootRequestStatus(request.Status, status) {
+		return nil
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return fmt.Errorf("failed to convert status: %w", err)
+	}
+	updated := obj.DeepCopy()
+	if err := unstructured.SetNestedField(updated.Object, content, "status"); err != nil {
+		return fmt.Errorf("failed to set status: %w", err)
+	}
+	if c.dryRun {
+		logger.Info("Dry run: would update reboot request status", "status", content)
+		return nil
+	}
+	updateCtx, cancel := context.WithTimeout(ctx, c.apiTimeout)
+	defer cancel()
+	_, err = c.dynamicClient.Resource(rebootRequestResource).Namespace(namespace).UpdateStatus(updateCtx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	logger.Info("Reboot request status updated", "status", content)
+	return nil
+}
+
+// Helper function to work out a requested node's phase from its annotations, requesting the
+// reboot the first time the node is seen. The node is done once its last-rebooted annotation is
+// later than the request, and has failed once a phase of its reboot fails or the node flow
+// gives up on it after the request. Completed and Failed are final.
+//
+// The request time is stamped on the node along with the reboot annotation, so a request whose
+// status update was lost picks up where it left off instead of rebooting the node again.
+func (c *Controller) reconcileRequestedNode(ctx context.Context, previous RebootRequestStatus, createdAt metav1.Time, nodeName string) (NodeRebootStatus, error) {
+	keys := c.annotationKeys()
+	current := NodeRebootStatus{Name: nodeName}
+	for _, s := range previous.Nodes {
+		if s.Name == nodeName {
+			current = s
+		}
+	}
+	if rebootPhaseFinal(current.Phase) {
+		return current, nil
+	}
+
+	node, err := c.nodeLister.Get(nodeName)
+	if apierrors.IsNotFound(err) {
+		return NodeRebootStatus{Name: nodeName, Phase: RebootPhaseFailed, Message: "node not found or not watched by the agent"}, nil
+	}
+	if err != nil {
+		return current, err
+	}
+
+	if current.Phase == "" {
+		// First time round - hand the node to the annotation flow, unless this request did so
+		// already or the node is about to reboot anyway
+		requestedAt, found := rebootRequestedAt(node, keys, createdAt)
+		if _, reboot := node.Annotations[keys.Reboot]; !found && (reboot || rebootInProgress(node, keys)) {
+			requestedAt, found = createdAt, true
+		}
+		if !found {
+			requestedAt = metav1.NewTime(time.Now().Truncate(time.Second))
+			err := patchNodeAnnotations(ctx, c.logger.With("node", nodeName), c.clientset, nodeName, c.apiTimeout, c.conflictBackoff, c.dryRun, map[string]*string{
+				keys.Reboot:            ptr.To(""),
+				keys.RebootRequestedAt: ptr.To(requestedAt.UTC().Format(time.RFC3339)),
+			})
+			if err != nil {
+				return current, fmt.Errorf("failed to set %s annotation on node %s: %w", keys.Reboot, nodeName, err)
+			}
+			return NodeRebootStatus{Name: nodeName, Phase: RebootPhasePending, RequestedAt: &requestedAt}, nil
+		}
+		current.RequestedAt = &requestedAt
+	}
+
+	message, failed := c.nodeFailedSince(nodeName, current.RequestedAt)
+	switch {
+	case rebootedSince(node, keys, current.RequestedAt):
+		current.Phase = RebootPhaseCompleted
+	case failed:
+		current.Phase, current.Message = RebootPhaseFailed, message
+	case rebootInProgress(node, keys):
+		current.Phase = RebootPhaseInProgress
+	default:
+		current.Phase = RebootPhasePending
+	}
+	return current, nil
+}
+
+// Helper function to read when a reboot was last requested on the node for a RebootRequest, if
+// that was for a request created at createdAt or later
+func rebootRequestedAt(node *v1.Node, keys AnnotationKeys, createdAt metav1.Time) (metav1.Time, bool) {
+	requestedAt, err := time.Parse(time.RFC3339, node.Annotations[keys.RebootRequestedAt])
+	if err != nil || requestedAt.Before(createdAt.Time.Truncate(time.Second)) {
+		return metav1.Time{}, false
+	}
+	return metav1.NewTime(requestedAt), true
+}
+
+// Helper function to check whether the node finished a reboot at or after the given time
+func rebootedSince(node *v1.Node, keys AnnotationKeys, since *metav1.Time) bool {
+	lastReboot, err := time.Parse(time.RFC3339, lastReboot(node, keys))
+	if err != nil || since == nil {
+		return false
+	}
+	return !lastReboot.Before(since.Time)
+}
+
+// Helper function to compare two statuses
+func equalRebootRequestStatus(a, b RebootRequestStatus) bool {
+	return equality.Semantic.DeepEqual(a, b)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Helper function to build a RebootRequest for the given nodes, created at createdAt
+func testRebootRequest(name string, createdAt time.Time, nodeNames ...interface{}) *unstructured.Unstructured {
+	request := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "reboot-agent.sdlt.local/v1alpha1",
+		"kind":       "RebootRequest",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       map[string]interface{}{"nodeNames": nodeNames},
+	}}
+	request.SetCreationTimestamp(metav1.NewTime(createdAt))
+	return request
+}
+
+// Helper function to enable RebootRequests on a test controller, served by a fake dynamic
+// client seeded with requests
+func enableTestRebootRequests(t *testing.T, c *Controller, requests ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{rebootRequestResource: "RebootRequestList"}, requests...)
+	c.dynamicClient = client
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	informer := factory.ForResource(rebootRequestResource).Informer()
+	if err := c.EnableRebootRequests(informer); err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() {
+		close(stopCh)
+		c.rebootRequestQueue.ShutDown()
+	})
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("reboot request cache did not sync")
+	}
+	return client
+}
+
+// Helper function to get a RebootRequest's status
+func rebootRequestStatus(t *testing.T, client *dynamicfake.FakeDynamicClient, name string) RebootRequestStatus {
+	t.Helper()
+	obj, err := client.Resource(rebootRequestResource).Namespace("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request RebootRequest
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &request); err != nil {
+		t.Fatal(err)
+	}
+	return request.Status
+}
+
+func TestRebootRequestAnnotatesNode(t *testing.T) {
+	keys := testKeys(t)
+	c, client := newTestController(t, testNode("node-1", nil))
+	dynamicClient := enableTestRebootRequests(t, c, testRebootRequest("patch-tuesday", time.Now(), "node-1", "node-gone"))
+
+	if err := c.syncRebootRequest(context.Background(), "default/patch-tuesday"); err != nil {
+		t.Fatalf("syncRebootRequest() failed: %v", err)
+	}
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, reboot := node.Annotations[keys.Reboot]; !reboot || node.Annotations[keys.RebootRequestedAt] == "" {
+		t.Errorf("node annotations = %v, want %s and %s", node.Annotations, keys.Reboot, keys.RebootRequestedAt)
+	}
+	status := rebootRequestStatus(t, dynamicClient, "patch-tuesday")
+	if len(status.Nodes) != 2 || status.Nodes[0].Phase != RebootPhasePending || status.Nodes[0].RequestedAt == nil || status.Nodes[1].Phase != RebootPhaseFailed {
+		t.Errorf("status = %+v, want node-1 Pending with a request time and node-gone Failed", status)
+	}
+}
+
+func TestRebootRequestLostStatusDoesNotRebootAgain(t *testing.T) {
+	keys := testKeys(t)
+	c, client := newTestController(t, testNode("node-1", nil))
+	dynamicClient := enableTestRebootRequests(t, c, testRebootRequest("patch-tuesday", time.Now().Add(-time.Minute), "node-1"))
+	// The status update after annotating the node is lost
+	lost := false
+	dynamicClient.PrependReactor("update", "rebootrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if lost {
+			return false, nil, nil
+		}
+		lost = true
+		return true, nil, apierrors.NewConflict(rebootRequestResource.GroupResource(), "patch-tuesday", errors.New("the object has been modified"))
+	})
+	if err := c.syncRebootRequest(context.Background(), "default/patch-tuesday"); err == nil {
+		t.Fatal("syncRebootRequest() succeeded despite the failed status update")
+	}
+
+	// Meanwhile the node rebooted
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(node.Annotations, keys.Reboot)
+	node.Annotations[keys.LastReboot] = time.Now().Add(time.Second).UTC().Format(time.RFC3339)
+	if _, err := client.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForCachedNode(t, c, "node-1", func(node *v1.Node) bool { return node.Annotations[keys.LastReboot] != "" })
+
+	if err := c.syncRebootRequest(context.Background(), "default/patch-tuesday"); err != nil {
+		t.Fatalf("syncRebootRequest() retry failed: %v", err)
+	}
+	node, err = client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, reboot := node.Annotations[keys.Reboot]; reboot {
+		t.Error("retried request asked for a second reboot")
+	}
+	if status := rebootRequestStatus(t, dynamicClient, "patch-tuesday"); len(status.Nodes) != 1 || status.Nodes[0].Phase != RebootPhaseCompleted {
+		t.Errorf("status = %+v, want node-1 Completed", status)
+	}
+}
+
+func TestRebootRequestLeavesPendingRebootAlone(t *testing.T) {
+	keys := testKeys(t)
+	c, client := newTestController(t, testNode("node-1", map[string]string{keys.Reboot: `{"priority":5}`}))
+	enableTestRebootRequests(t, c, testRebootRequest("patch-tuesday", time.Now(), "node-1"))
+	client.ClearActions()
+
+	if err := c.syncRebootRequest(context.Background(), "default/patch-tuesday"); err != nil {
+		t.Fatalf("syncRebootRequest() failed: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("node already asking for a reboot was patched: %v", action)
+		}
+	}
+}
+
+func TestRebootedSince(t *testing.T) {
+	keys := testKeys(t)
+	since := metav1.NewTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name       string
+		lastReboot string
+		since      *metav1.Time
+		want       bool
+	}{
+		{"never rebooted", "", &since, false},
+		{"invalid", "yesterday", &since, false},
+		{"before", "2024-01-01T11:59:59Z", &since, false},
+		{"at", "2024-01-01T12:00:00Z", &since, true},
+		{"after", "2024-01-01T12:30:00Z", &since, true},
+		{"no request time", "2024-01-01T12:30:00Z", nil, false},
+	}
+	for _, tt := range tests {
+		node := testNode("node-1", nil)
+		if tt.lastReboot != "" {
+			node.Annotations = map[string]string{keys.LastReboot: tt.lastReboot}
+		}
+		if got := rebootedSince(node, keys, tt.since); got != tt.want {
+			t.Errorf("%s: rebootedSince() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A reboot recorded by an earlier version, under the legacy key, still counts
+	node := testNode("node-1", map[string]string{keys.LegacyLastReboot: "2024-01-01T12:30:00Z"})
+	if !rebootedSince(node, keys, &since) {
+		t.Error("rebootedSince() ignored the legacy last reboot annotation")
+	}
+}
+
+func TestRebootRequestReportsFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    func(c *Controller)
+		message string
+	}{
+		{"failed phase", func(c *Controller) {
+			c.recordNodeFailure("node-1", (&RebootError{Node: "node-1", Phase: phaseDrain, Err: errors.New("eviction refused")}).Error())
+		}, "eviction refused"},
+		{"retries exhausted", func(c *Controller) {
+			c.requeueBackoff.Steps = 1
+			c.processNextItem(context.Background(), c.nodeQueue, func(context.Context, string) error { return errors.New("apiserver unavailable") })
+		}, "giving up after 1 failed attempts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestController(t, testNode("node-1", nil))
+			t.Cleanup(c.nodeQueue.ShutDown)
+			dynamicClient := enableTestRebootRequests(t, c, testRebootRequest("patch-tuesday", time.Now().Add(-time.Minute), "node-1"))
+			if err := c.syncRebootRequest(context.Background(), "default/patch-tuesday"); err != nil {
+				t.Fatalf("syncRebootRequest() failed: %v", err)
+			}
+			waitForRebootRequestStatus(t, c, "patch-tuesday", func(status RebootRequestStatus) bool { return len(status.Nodes) == 1 })
+
+			c.nodeQueue.Add("node-1")
+			tt.fail(c)
+			if err := c.syncRebootRequest(context.Background(), "default/patch-tuesday"); err != nil {
+				t.Fatalf("syncRebootRequest() failed: %v", err)
+			}
+			status := rebootRequestStatus(t, dynamicClient, "patch-tuesday")
+			if len(status.Nodes) != 1 || status.Nodes[0].Phase != RebootPhaseFailed || !strings.Contains(status.Nodes[0].Message, tt.message) {
+				t.Fatalf("status = %+v, want node-1 Failed with %q", status, tt.message)
+			}
+
+			// A finished request is left alone, and not checked back on
+			waitForRebootRequestStatus(t, c, "patch-tuesday", func(status RebootRequestStatus) bool {
+				return len(status.Nodes) == 1 && status.Nodes[0].Phase == RebootPhaseFailed
+			})
+			for c.rebootRequestQueue.Len() > 0 {
+				key, _ := c.rebootRequestQueue.Get()
+				c.rebootRequestQueue.Done(key)
+			}
+			dynamicClient.ClearActions()
+			if err := c.syncRebootRequest(context.Background(), "default/patch-tuesday"); err != nil {
+				t.Fatalf("syncRebootRequest() of the finished request failed: %v", err)
+			}
+			if actions := dynamicClient.Actions(); len(actions) != 0 {
+				t.Errorf("finished request updated: %v", actions)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if c.rebootRequestQueue.Len() != 0 {
+				t.Error("finished request requeued")
+			}
+		})
+	}
+}
+
+// Helper function to wait for the RebootRequest cache to hold a status of the request done
+// reports true for
+func waitForRebootRequestStatus(t *testing.T, c *Controller, name string, done func(RebootRequestStatus) bool) {
+	t.Helper()
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+		obj, err := c.rebootRequestLister.Namespace("default").Get(name)
+		if err != nil {
+			return false, nil
+		}
+		var request RebootRequest
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &request); err != nil {
+			return false, err
+		}
+		return done(request.Status), nil
+	})
+	if err != nil {
+		t.Fatalf("RebootRequest %s status never cached: %v", name, err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// Phases reported by /reboots, from most to least urgent
+const (
+	nodePhaseStuck      = "stuck"
+	nodePhaseInProgress = "in-progress"
+	nodePhaseRequested  = "requested"
+	nodePhaseOptedOut   = "opted-out"
+	nodePhaseIdle       = "idle"
+)
+
+// nodeRebootState is one entry of the /reboots response
+type nodeRebootState struct {
+	Name        string            `json:"name"`
+	Phase       string            `json:"phase"`
+	LastReboot  string            `json:"lastReboot,omitempty"`
+	RebootCount int               `json:"rebootCount"`
+	Annotations map[string]string `json:"annotations"`
+	// Why the last reboot decision on the node allowed or blocked a reboot, and when
+	Decision  string `json:"decision,omitempty"`
+	DecidedAt string `json:"decidedAt,omitempty"`
+}
+
+// decisionLog keeps the reason of the last reboot decision made for each node, so /reboots
+// can answer why a node isn't rebooting. A nil decisionLog records nothing.
+type decisionLog struct {
+	mu        sync.Mutex
+	decisions map[string]loggedDecision
+}
+
+type loggedDecision struct {
+	reason string
+	at     time.Time
+}
+
+func newDecisionLog() *decisionLog {
+	return &decisionLog{decisions: make(map[string]loggedDecision)}
+}
+
+// record keeps the reason of the latest decision on the node
+func (l *decisionLog) record(nodeName, reason string, at time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decisions[nodeName] = loggedDecision{reason: reason, at: at}
+}
+
+// forget drops a deleted node's decision
+func (l *decisionLog) forget(nodeName string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.decisions, nodeName)
+}
+
+// Helper function to look up the last decision on the node
+func (l *decisionLog) get(nodeName string) (loggedDecision, bool) {
+	if l == nil {
+		return loggedDecision{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	decision, ok := l.decisions[nodeName]
+	return decision, ok
+}
+
+// rebootsHandler serves GET /reboots: the cached nodes carrying any reboot annotation, their
+// phase and the reason of the last reboot decision on them, optionally filtered with ?phase=.
+// It only reads the node cache, never the API server. Keys are looked up per request, as the
+// annotation ConfigMap can change them.
+func rebootsHandler(nodeLister corelisters.NodeLister, annotationKeys func() AnnotationKeys, stuckTimeout time.Duration, decisions *decisionLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		phase := r.URL.Query().Get("phase")
+		switch phase {
+		case "", nodePhaseStuck, nodePhaseInProgress, nodePhaseRequested, nodePhaseOptedOut, nodePhaseIdle:
+		default:
+			http.Error(w, fmt.Sprintf("unknown phase %q", phase), http.StatusBadRequest)
+			return
+		}
+
+		nodes, err := nodeLister.List(labels.Everything())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		keys := annotationKeys()
+		states := []nodeRebootState{}
+		now := time.Now()
+		for _, node := range nodes {
+			annotations := rebootAnnotations(node, keys)
+			if len(annotations) == 0 {
+				continue
+			}
+			state := nodeRebootState{
+				Name:        node.Name,
+				Phase:       nodeRebootPhase(node, keys, stuckTimeout, now),
+				LastReboot:  lastReboot(node, keys),
+				RebootCount: rebootCount(node, keys),
+				Annotations: annotations,
+			}
+			if decision, ok := decisions.get(node.Name); ok {
+				state.Decision = decision.reason
+				state.DecidedAt = decision.at.UTC().Format(time.RFC3339)
+			}
+			if phase != "" && state.Phase != phase {
+				continue
+			}
+			states = append(states, state)
+		}
+		sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	})
+}
+
+// Helper function to pick out the node's annotations under the agent's prefix
+func rebootAnnotations(node *v1.Node, keys AnnotationKeys) map[string]string {
+	annotations := map[string]string{}
+	for _, key := range []string{keys.Reboot, keys.RebootNeeded, keys.RebootInProgress, keys.RebootID,
+		keys.NoReboot, keys.CordonedByAgent, keys.LastReboot, keys.LegacyLastReboot, keys.BootID, keys.RebootReason, keys.RebootReasonDetail, keys.RebootCount, keys.DrainTimeout} {
+		if value, ok := node.Annotations[key]; ok {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// Helper function to work out where a node is in the reboot flow from its annotations
+func nodeRebootPhase(node *v1.Node, keys AnnotationKeys, stuckTimeout time.Duration, now time.Time) string {
+	_, requested := node.Annotations[keys.Reboot]
+	_, needed := node.Annotations[keys.RebootNeeded]
+	_, optedOut := node.Annotations[keys.NoReboot]
+	switch {
+	case rebootInProgress(node, keys) && rebootStuck(node, keys, stuckTimeout, now):
+		return nodePhaseStuck
+	case rebootInProgress(node, keys):
+		return nodePhaseInProgress
+	case optedOut:
+		return nodePhaseOptedOut
+	case requested || needed:
+		return nodePhaseRequested
+	default:
+		return nodePhaseIdle
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Helper function to build a node lister over the given nodes
+func testNodeLister(t *testing.T, nodes ...*v1.Node) corelisters.NodeLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return corelisters.NewNodeLister(indexer)
+}
+
+// Helper function to GET /reboots with the given query and decode the response
+func getReboots(t *testing.T, handler http.Handler, query string) []nodeRebootState {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/reboots"+query, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /reboots%s = %d: %s", query, recorder.Code, recorder.Body.String())
+	}
+	var states []nodeRebootState
+	if err := json.NewDecoder(recorder.Body).Decode(&states); err != nil {
+		t.Fatal(err)
+	}
+	return states
+}
+
+func TestRebootsReportsDecisions(t *testing.T) {
+	keys := testKeys(t)
+	lister := testNodeLister(t,
+		testNode("node-1", map[string]string{keys.Reboot: ""}),
+		testNode("node-2", map[string]string{keys.Reboot: ""}),
+	)
+	decidedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	decisions := newDecisionLog()
+	decisions.record("node-1", reasonOutsideWindow, decidedAt)
+
+	states := getReboots(t, rebootsHandler(lister, func() AnnotationKeys { return keys }, 30*time.Minute, decisions), "")
+	if len(states) != 2 {
+		t.Fatalf("got %d nodes, want 2: %+v", len(states), states)
+	}
+	if states[0].Decision != reasonOutsideWindow || states[0].DecidedAt != "2024-01-01T12:00:00Z" {
+		t.Errorf("node-1 decision = %q at %q, want %q at 2024-01-01T12:00:00Z", states[0].Decision, states[0].DecidedAt, reasonOutsideWindow)
+	}
+	if states[1].Decision != "" {
+		t.Errorf("node-2 has no decision yet, got %q", states[1].Decision)
+	}
+
+	decisions.forget("node-1")
+	if states := getReboots(t, rebootsHandler(lister, func() AnnotationKeys { return keys }, 30*time.Minute, decisions), ""); states[0].Decision != "" {
+		t.Errorf("node-1 decision after forget = %q, want none", states[0].Decision)
+	}
+}
+
+func TestRebootsPhases(t *testing.T) {
+	keys := testKeys(t)
+	now := time.Now().UTC()
+	lister := testNodeLister(t,
+		testNode("idle", map[string]string{keys.LastReboot: now.Add(-24 * time.Hour).Format(time.RFC3339), keys.RebootCount: "3"}),
+		testNode("opted-out", map[string]string{keys.NoReboot: "", keys.Reboot: ""}),
+		testNode("rebooting", map[string]string{keys.RebootInProgress: now.Add(-time.Minute).Format(time.RFC3339)}),
+		testNode("requested", map[string]string{keys.RebootNeeded: ""}),
+		testNode("stuck", map[string]string{keys.RebootInProgress: now.Add(-time.Hour).Format(time.RFC3339)}),
+		testNode("unannotated", nil),
+	)
+	handler := rebootsHandler(lister, func() AnnotationKeys { return keys }, 30*time.Minute, nil)
+
+	want := map[string]string{
+		"idle":      nodePhaseIdle,
+		"opted-out": nodePhaseOptedOut,
+		"rebooting": nodePhaseInProgress,
+		"requested": nodePhaseRequested,
+		"stuck":     nodePhaseStuck,
+	}
+	states := getReboots(t, handler, "")
+	if len(states) != len(want) {
+		t.Fatalf("got %d nodes, want %d without the unannotated node: %+v", len(states), len(want), states)
+	}
+	for _, state := range states {
+		if state.Phase != want[state.Name] {
+			t.Errorf("%s phase = %q, want %q", state.Name, state.Phase, want[state.Name])
+		}
+	}
+	if states[0].Name != "idle" || states[0].RebootCount != 3 || states[0].LastReboot == "" {
+		t.Errorf("idle node reported as %+v, want its reboot count and last reboot", states[0])
+	}
+
+	for name, phase := range want {
+		states := getReboots(t, handler, "?phase="+phase)
+		if len(states) != 1 || states[0].Name != name {
+			t.Errorf("?phase=%s returned %+v, want only %s", phase, states, name)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/reboots?phase=rebooted", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("GET /reboots?phase=rebooted = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
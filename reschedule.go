@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// drainedOwner is a controller, such as a ReplicaSet or StatefulSet, with pods a drain evicts
+type drainedOwner struct {
+	namespace string
+	kind      string
+	name      string
+	uid       types.UID
+}
+
+// Helper function to count, per controller, the pods on a node a drain will evict. Pods without
+// a controller are left out, nothing recreates them elsewhere.
+func drainedOwners(ctx context.Context, client kubernetes.Interface, nodeName string, filter drainFilter, apiTimeout time.Duration) (map[drainedOwner]int, error) {
+	pods, err := listNodePods(ctx, client, nodeName, apiTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+	}
+	owners := map[drainedOwner]int{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !evictable(pod) || !filter.allows(pod.Namespace) {
+			continue
+		}
+		if owner := metav1.GetControllerOf(pod); owner != nil {
+			owners[drainedOwner{namespace: pod.Namespace, kind: owner.Kind, name: owner.Name, uid: owner.UID}]++
+		}
+	}
+	return owners, nil
+}
+
+// waitForReschedule waits until every owner has at least as many Ready pods on other nodes as
+// the drain evicted from nodeName, polling every drainPollInterval. Gives up when ctx is done.
+func waitForReschedule(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, nodeName string, owners map[drainedOwner]int, apiTimeout time.Duration) error {
+	err := wait.PollUntilContextCancel(ctx, drainPollInterval, true, func(ctx context.Context) (bool, error) {
+		for owner, evicted := range owners {
+			ready, err := readyPodsElsewhere(ctx, client, owner, nodeName, apiTimeout)
+			if err != nil {
+				return false, err
+			}
+			if ready < evicted {
+				logger.Debug("Waiting for evicted pods to be rescheduled", "owner", owner.kind+"/"+owner.name, "namespace", owner.namespace, "ready", ready, "evicted", evicted)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for pods evicted from node %s to be rescheduled: %w", nodeName, err)
+	}
+	return nil
+}
+
+// Helper function to count an owner's Ready pods that aren't on the given node or terminating
+func readyPodsElsewhere(ctx context.Context, client kubernetes.Interface, owner drainedOwner, nodeName string, apiTimeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+	pods, err := client.CoreV1().Pods(owner.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pods in namespace %s: %w", owner.namespace, err)
+	}
+	ready := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		controller := metav1.GetControllerOf(pod)
+		if controller == nil || controller.UID != owner.uid || pod.Spec.NodeName == nodeName || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if podReady(pod) {
+			ready++
+		}
+	}
+	return ready, nil
+}
+
+// Helper function to check a pod's Ready condition
+func podReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Helper function to build a pod of the ReplicaSet with the given UID, Ready or not
+func testReplica(name, nodeName string, uid types.UID, ready bool) *v1.Pod {
+	pod := testPod("default", name, nodeName, "ReplicaSet")
+	pod.OwnerReferences[0].Name = "web"
+	pod.OwnerReferences[0].UID = uid
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: status}}
+	return pod
+}
+
+func TestWaitForReschedule(t *testing.T) {
+	previous := drainPollInterval
+	drainPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { drainPollInterval = previous })
+
+	owners := map[drainedOwner]int{{namespace: "default", kind: "ReplicaSet", name: "web", uid: "rs-1"}: 2}
+	tests := []struct {
+		name     string
+		pods     []runtime.Object
+		wantDone bool
+	}{
+		{"replacements Ready elsewhere", []runtime.Object{testReplica("web-a", "node-2", "rs-1", true), testReplica("web-b", "node-3", "rs-1", true)}, true},
+		{"one replacement not Ready", []runtime.Object{testReplica("web-a", "node-2", "rs-1", true), testReplica("web-b", "node-3", "rs-1", false)}, false},
+		{"still on the drained node", []runtime.Object{testReplica("web-a", "node-2", "rs-1", true), testReplica("web-b", "node-1", "rs-1", true)}, false},
+		{"another owner's pods", []runtime.Object{testReplica("web-a", "node-2", "rs-1", true), testReplica("web-b", "node-2", "rs-2", true)}, false},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(tt.pods...)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err := waitForReschedule(ctx, discardLogger(), client, "node-1", owners, time.Second)
+		cancel()
+		if (err == nil) != tt.wantDone {
+			t.Errorf("%s: waitForReschedule() error = %v, want done %v", tt.name, err, tt.wantDone)
+		}
+	}
+}
+
+func TestDrainedOwners(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testReplica("web-1", "node-1", "rs-1", true),
+		testReplica("web-2", "node-1", "rs-1", true),
+		testPod("default", "bare", "node-1", ""),
+		testPod("kube-system", "fluentd", "node-1", "DaemonSet"),
+	)
+	owners, err := drainedOwners(context.Background(), client, "node-1", drainFilter{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[drainedOwner]int{{namespace: "default", kind: "ReplicaSet", name: "web", uid: "rs-1"}: 2}
+	if len(owners) != len(want) || owners[drainedOwner{namespace: "default", kind: "ReplicaSet", name: "web", uid: "rs-1"}] != 2 {
+		t.Errorf("drainedOwners() = %v, want %v", owners, want)
+	}
+}
+
+func TestRebootGoesAheadAfterRescheduleTimeout(t *testing.T) {
+	keys := testKeys(t)
+	c, client := newTestController(t, testNode("node-1", map[string]string{keys.Reboot: ""}), testReplica("web-1", "node-1", "rs-1", true))
+	reactToEvictions(t, client, nil)
+	c.rescheduleWait = 50 * time.Millisecond
+
+	if err := c.syncNode(context.Background(), "node-1"); err != nil {
+		t.Fatalf("syncNode() failed: %v", err)
+	}
+	if got := nodesInProgress(t, client, keys); len(got) != 1 {
+		t.Errorf("nodes rebooting = %v, want node-1 rebooting after the wait timed out", got)
+	}
+	timedOut := false
+	for _, event := range recordedEvents(c.recorder) {
+		timedOut = timedOut || strings.HasPrefix(event, "Warning "+eventRescheduleTimedOut+" ")
+	}
+	if !timedOut {
+		t.Errorf("no Warning %s event", eventRescheduleTimedOut)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Outcomes of a RebootResult
+const (
+	RebootOutcomeCompleted = "completed"
+	RebootOutcomeFailed    = "failed"
+)
+
+// RebootResult reports how a node's reboot ended, for programs embedding the controller
+type RebootResult struct {
+	Node     string
+	RebootID string
+	Outcome  string // RebootOutcomeCompleted or RebootOutcomeFailed
+	Phase    string // Phase the reboot failed in, empty once completed
+	Err      error  // Why the phase failed, nil once completed
+	// How long the cordon and drain took, 0 if they were skipped or run before a restart
+	DrainDuration time.Duration
+	// From the node being marked reboot-in-progress to it coming back, 0 unless completed
+	RebootDuration time.Duration
+	Finished       time.Time
+}
+
+// rebootResults delivers RebootResults on a buffered channel without ever blocking the reboot
+// flow, and remembers drain durations until the reboot they belong to ends. A nil
+// rebootResults delivers nothing.
+type rebootResults struct {
+	logger *slog.Logger
+	ch     chan RebootResult
+
+	mu     sync.Mutex
+	drains map[string]time.Duration
+}
+
+// RebootResults returns a channel delivering a RebootResult each time a node's reboot completes
+// or one of its phases fails. Failed phases are retried, so one reboot can deliver several
+// failures before it completes. Must be called before Start; later calls return the same
+// channel and ignore buffer.
+//
+// The channel buffers up to buffer results. Sending never blocks the reboot flow: while the
+// buffer is full, new results are dropped, logged and counted in
+// reboot_agent_reboot_results_dropped_total, so the reader must keep up. The channel is never
+// closed, as workers still finishing after a shutdown timeout may send on it.
+func (c *Controller) RebootResults(buffer int) <-chan RebootResult {
+	if c.results == nil {
+		c.results = &rebootResults{logger: c.logger, ch: make(chan RebootResult, max(buffer, 0)), drains: map[string]time.Duration{}}
+	}
+	return c.results.ch
+}
+
+// Helper function to note how long the drain before a node's reboot took
+func (r *rebootResults) drained(node string, took time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drains[node] = took
+}
+
+// Helper function to deliver a result, filling in the drain duration noted for its node
+func (r *rebootResults) send(result RebootResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	result.DrainDuration = r.drains[result.Node]
+	if result.Outcome == RebootOutcomeCompleted {
+		delete(r.drains, result.Node)
+	}
+	r.mu.Unlock()
+
+	result.Finished = time.Now()
+	select {
+	case r.ch <- result:
+	default:
+		rebootResultsDroppedTotal.Inc()
+		r.logger.Warn("Reboot result buffer full, dropping result", "node", result.Node, "reboot_id", result.RebootID, "outcome", result.Outcome)
+	}
+}
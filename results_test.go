@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRebootResults(t *testing.T) {
+	keys := testKeys(t)
+	node := testNode("node-1", map[string]string{keys.Reboot: ""})
+	node.Status.NodeInfo.BootID = "boot-1"
+	c, client := newTestController(t, node, testPod("default", "web-1", "node-1", "ReplicaSet"))
+	reactToEvictions(t, client, nil)
+	results := c.RebootResults(4)
+	rebootCycle(t, c, client, "node-1")
+
+	select {
+	case result := <-results:
+		if result.Node != "node-1" || result.Outcome != RebootOutcomeCompleted || result.Err != nil || result.RebootID == "" {
+			t.Errorf("result = %+v, want node-1 completed with its reboot ID", result)
+		}
+		if result.DrainDuration <= 0 || result.Finished.IsZero() {
+			t.Errorf("result = %+v, want the drain duration and finish time", result)
+		}
+	default:
+		t.Fatal("no result delivered for the completed reboot")
+	}
+	if len(results) != 0 {
+		t.Errorf("%d more results delivered, want only the completion", len(results))
+	}
+}
+
+func TestRebootResultsFailure(t *testing.T) {
+	keys := testKeys(t)
+	c, _ := newTestController(t, testNode("node-1", map[string]string{keys.Reboot: ""}))
+	c.rebooter = failingRebooter{err: errors.New("connection refused")}
+	results := c.RebootResults(1)
+
+	if err := c.syncNode(context.Background(), "node-1"); err == nil {
+		t.Fatal("syncNode() succeeded with a failing rebooter")
+	}
+	select {
+	case result := <-results:
+		if result.Outcome != RebootOutcomeFailed || result.Phase != phaseReboot || result.Err == nil || result.RebootID == "" {
+			t.Errorf("result = %+v, want a failure in the %s phase with the error", result, phaseReboot)
+		}
+	default:
+		t.Fatal("no result delivered for the failed reboot")
+	}
+}
+
+func TestRebootResultsDropWhenFull(t *testing.T) {
+	c, _ := newTestController(t)
+	results := c.RebootResults(1)
+	dropped := testutil.ToFloat64(rebootResultsDroppedTotal)
+
+	// The second send finds the buffer full and must not block
+	c.results.send(RebootResult{Node: "node-1", Outcome: RebootOutcomeCompleted})
+	c.results.send(RebootResult{Node: "node-2", Outcome: RebootOutcomeCompleted})
+	if got := testutil.ToFloat64(rebootResultsDroppedTotal) - dropped; got != 1 {
+		t.Errorf("reboot_agent_reboot_results_dropped_total rose by %v, want 1", got)
+	}
+	if result := <-results; result.Node != "node-1" {
+		t.Errorf("delivered %s, want the first result kept", result.Node)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// rolloutRestart describes how to trigger a restart on a rollout CR that owns ReplicaSets
+// in place of a Deployment: the resource to patch and the path of the field to set to the
+// current time.
+type rolloutRestart struct {
+	Resource  string
+	FieldPath []string
+}
+
+// Rollout CRDs whose restart convention is known. Others can be added through the
+// rollout-restarts section of the config file.
+var rolloutRestarts = map[schema.GroupKind]rolloutRestart{
+	{Group: "argoproj.io", Kind: "Rollout"}: {Resource: "rollouts", FieldPath: []string{"spec", "restartAt"}},
+}
+
+// addRolloutRestarts adds restart conventions from the config file to rolloutRestarts,
+// replacing built-in ones for the same kind. Call before the controller starts.
+func addRolloutRestarts(restarts []RolloutRestartConfig) error {
+	for _, restart := range restarts {
+		gk := schema.GroupKind{Group: restart.Group, Kind: restart.Kind}
+		if restart.Kind == "" || restart.Resource == "" || restart.FieldPath == "" {
+			return fmt.Errorf("rollout restart for %q needs a kind, resource and fieldPath", gk.String())
+		}
+		if restartableKinds[gk] {
+			return fmt.Errorf("rollout restart for %s: the kind is already restarted natively", gk.String())
+		}
+		path := strings.Split(restart.FieldPath, ".")
+		if slices.Contains(path, "") {
+			return fmt.Errorf("rollout restart for %s: invalid fieldPath %q", gk.String(), restart.FieldPath)
+		}
+		rolloutRestarts[gk] = rolloutRestart{Resource: restart.Resource, FieldPath: path}
+	}
+	return nil
+}
+
+// Function to restart a rollout CR owning the pod's replicaset via the dynamic client. The
+// logger is expected to carry the owner's kind and name. Reports whether the rollout was
+// restarted, which it isn't for a kind without a known restart convention or in dry-run mode.
+func restartRollout(ctx context.Context, logger *slog.Logger, namespace string, ownerRef metav1.OwnerReference, dynamicClient dynamic.Interface, dryRun bool) (bool, error) {
+	gv, err := schema.ParseGroupVersion(ownerRef.APIVersion)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse apiVersion of %s %s: %w", ownerRef.Kind, ownerRef.Name, err)
+	}
+
+	restart, ok := rolloutRestarts[gv.WithKind(ownerRef.Kind).GroupKind()]
+	if !ok {
+		logger.Warn("Unknown restart convention for owner, skipping", "apiVersion", ownerRef.APIVersion)
+		return false, nil
+	}
+
+	patch, err := json.Marshal(nestedPatch(restart.FieldPath, time.Now().Format(time.RFC3339)))
+	if err != nil {
+		return false, fmt.Errorf("failed to build restart patch for %s %s: %w", ownerRef.Kind, ownerRef.Name, err)
+	}
+	if dryRun {
+		logger.Info("Dry run: would patch rollout", "patch", string(patch))
+		return false, nil
+	}
+
+	_, err = dynamicClient.Resource(gv.WithResource(restart.Resource)).Namespace(namespace).
+		Patch(ctx, ownerRef.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to patch %s %s: %w", ownerRef.Kind, ownerRef.Name, err)
+	}
+	logger.Info("Rollout restarted")
+	return true, nil
+}
+
+// How often waitForDeploymentRollout checks on the Deployment. A variable so tests don't wait
+// out real polls.
+var rolloutPollInterval = 5 * time.Second
+
+// waitForDeploymentRollout polls a just-restarted Deployment until its controller has seen the
+// restart and every replica runs the new template, or the timeout elapses, and logs which. The
+// outcome isn't returned as an error, the restart itself went through.
+func waitForDeploymentRollout(ctx context.Context, logger *slog.Logger, clientset kubernetes.Interface, deployment *appsv1.Deployment, timeout, apiTimeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := wait.PollUntilContextCancel(ctx, rolloutPollInterval, false, func(ctx context.Context) (bool, error) {
+		getCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+		current, err := clientset.AppsV1().Deployments(deployment.Namespace).Get(getCtx, deployment.Name, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			logger.Debug("Failed to get deployment, retrying", "error", err)
+			return false, nil
+		}
+		return rolloutComplete(current, deployment.Generation)
+	})
+	if err != nil {
+		logger.Warn("Rollout did not complete", "timeout", timeout, "error", err)
+		return
+	}
+	logger.Info("Rollout completed")
+}
+
+// Helper function to check whether the Deployment's controller has observed the given
+// generation and finished rolling every replica onto it. A rollout past its progress deadline
+// is an error.
+func rolloutComplete(deployment *appsv1.Deployment, generation int64) (bool, error) {
+	if deployment.Status.ObservedGeneration < generation {
+		return false, nil
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("progress deadline exceeded: %s", condition.Message)
+		}
+	}
+	replicas := ptr.Deref(deployment.Spec.Replicas, 1)
+	status := deployment.Status
+	return status.UpdatedReplicas == replicas && status.Replicas == replicas && status.AvailableReplicas == replicas, nil
+}
+
+// Helper function to build a merge patch setting value at the given field path
+func nestedPatch(path []string, value interface{}) map[string]interface{} {
+	patch := map[string]interface{}{path[len(path)-1]: value}
+	for i := len(path) - 2; i >= 0; i-- {
+		patch = map[string]interface{}{path[i]: patch}
+	}
+	return patch
+}
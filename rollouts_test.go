@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+// Helper function to write a config file into a temporary directory, returning its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRolloutRestartsFromConfig(t *testing.T) {
+	gk := schema.GroupKind{Group: "flagger.example.com", Kind: "Canary"}
+	t.Cleanup(func() { delete(rolloutRestarts, gk) })
+
+	config, err := LoadConfig(writeConfig(t, `
+rollout-restarts:
+  - group: flagger.example.com
+    kind: Canary
+    resource: canaries
+    fieldPath: spec.template.restartedAt
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not a flag, so applying the file must leave it alone
+	if err := config.apply(flag.NewFlagSet("test", flag.ContinueOnError)); err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+	if err := addRolloutRestarts(config.RolloutRestarts); err != nil {
+		t.Fatalf("addRolloutRestarts() failed: %v", err)
+	}
+
+	canary := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "flagger.example.com/v1",
+		"kind":       "Canary",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+	}}
+	gvr := schema.GroupVersionResource{Group: "flagger.example.com", Version: "v1", Resource: "canaries"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "CanaryList"}, canary)
+
+	owner := metav1.OwnerReference{APIVersion: "flagger.example.com/v1", Kind: "Canary", Name: "web"}
+	if restarted, err := restartRollout(context.Background(), discardLogger(), "default", owner, client, false); err != nil || !restarted {
+		t.Fatalf("restartRollout() = %v, %v, want true, nil", restarted, err)
+	}
+	got, err := client.Resource(gvr).Namespace("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, found, _ := unstructured.NestedString(got.Object, "spec", "template", "restartedAt"); !found || value == "" {
+		t.Errorf("spec.template.restartedAt not set, object %v", got.Object)
+	}
+}
+
+func TestUnknownOwnerKindNotRestarted(t *testing.T) {
+	pod := testPod("default", "job-abc", "node-1", "")
+	owner := metav1.OwnerReference{APIVersion: "batch.example.com/v1", Kind: "Workflow", Name: "nightly"}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	c := newTestRestarter(t, fake.NewSimpleClientset(), client, record.NewFakeRecorder(10), newRestartCooldown(5*time.Minute))
+	restarts := testutil.ToFloat64(deploymentRestartsTotal.WithLabelValues("default", "Workflow"))
+
+	if err := c.triggerRolloutRestart(context.Background(), discardLogger(), owner, pod); err != nil {
+		t.Fatalf("triggerRolloutRestart() failed: %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("dynamic client called for an unknown kind: %v", actions)
+	}
+	if got := testutil.ToFloat64(deploymentRestartsTotal.WithLabelValues("default", "Workflow")) - restarts; got != 0 {
+		t.Errorf("reboot_agent_deployment_restarts_total rose by %v for an unknown kind, want 0", got)
+	}
+	key := restartKey{kind: "Workflow", NamespacedName: types.NamespacedName{Namespace: "default", Name: "nightly"}}
+	if c.restartCooldown.active(key) {
+		t.Error("cooldown started for a workload that wasn't restarted")
+	}
+}
+
+func TestAddRolloutRestartsRejectsInvalid(t *testing.T) {
+	for _, restart := range []RolloutRestartConfig{
+		{Group: "example.com", Kind: "Rollout", FieldPath: "spec.restartAt"},
+		{Group: "example.com", Kind: "Rollout", Resource: "rollouts", FieldPath: "spec..restartAt"},
+		{Group: "apps", Kind: "Deployment", Resource: "deployments", FieldPath: "spec.restartAt"},
+	} {
+		if err := addRolloutRestarts([]RolloutRestartConfig{restart}); err == nil {
+			t.Errorf("addRolloutRestarts(%+v) succeeded, want an error", restart)
+		}
+	}
+}
+
+func TestRolloutComplete(t *testing.T) {
+	status := func(observed int64, updated, total, available int32) *appsv1.Deployment {
+		deployment := testDeployment("default", "web", time.Time{})
+		deployment.Spec.Replicas = ptr.To(int32(3))
+		deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: observed, UpdatedReplicas: updated, Replicas: total, AvailableReplicas: available}
+		return deployment
+	}
+	stalled := status(2, 1, 3, 3)
+	stalled.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded", Message: "timed out"}}
+
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		want       bool
+		wantErr    bool
+	}{
+		{"restart not observed", status(1, 3, 3, 3), false, false},
+		{"rolling", status(2, 1, 4, 3), false, false},
+		{"old replicas terminating", status(2, 3, 4, 3), false, false},
+		{"not yet available", status(2, 3, 3, 2), false, false},
+		{"complete", status(2, 3, 3, 3), true, false},
+		{"progress deadline exceeded", stalled, false, true},
+	}
+	for _, tt := range tests {
+		got, err := rolloutComplete(tt.deployment, 2)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: rolloutComplete() = %v, %v; want %v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWaitForDeploymentRollout(t *testing.T) {
+	previous := rolloutPollInterval
+	rolloutPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { rolloutPollInterval = previous })
+
+	// Each poll sees the rollout a step further along
+	steps := []appsv1.DeploymentStatus{
+		{ObservedGeneration: 1, UpdatedReplicas: 0, Replicas: 2, AvailableReplicas: 2},
+		{ObservedGeneration: 2, UpdatedReplicas: 1, Replicas: 3, AvailableReplicas: 2},
+		{ObservedGeneration: 2, UpdatedReplicas: 2, Replicas: 3, AvailableReplicas: 2},
+		{ObservedGeneration: 2, UpdatedReplicas: 2, Replicas: 2, AvailableReplicas: 2},
+	}
+	for _, tt := range []struct {
+		name      string
+		steps     []appsv1.DeploymentStatus
+		timeout   time.Duration
+		want      string
+		wantPolls int // 0 doesn't check
+	}{
+		{"completes", steps, 5 * time.Second, "Rollout completed", len(steps)},
+		{"times out", steps[:3], 200 * time.Millisecond, "Rollout did not complete", 0},
+	} {
+		deployment := testDeployment("default", "web", time.Time{})
+		deployment.Generation = 2
+		deployment.Spec.Replicas = ptr.To(int32(2))
+		client := fake.NewSimpleClientset(deployment)
+		polls := 0
+		client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			current := deployment.DeepCopy()
+			current.Status = tt.steps[min(polls, len(tt.steps)-1)]
+			polls++
+			return true, current, nil
+		})
+		var logs strings.Builder
+		logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+		waitForDeploymentRollout(context.Background(), logger, client, deployment, tt.timeout, time.Second)
+		if !strings.Contains(logs.String(), tt.want) {
+			t.Errorf("%s: logs don't say %q:\n%s", tt.name, tt.want, logs.String())
+		}
+		if tt.wantPolls > 0 && polls != tt.wantPolls {
+			t.Errorf("%s: polled %d times, want %d, stopping once complete", tt.name, polls, tt.wantPolls)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+)
+
+// parseTaint parses a taint in kubectl's key[=value]:effect form
+func parseTaint(spec string) (*v1.Taint, error) {
+	keyValue, effect, found := strings.Cut(spec, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid taint %q: expected key[=value]:effect", spec)
+	}
+	key, value, _ := strings.Cut(keyValue, "=")
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid taint key %q: %s", key, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid taint value %q: %s", value, strings.Join(errs, "; "))
+	}
+	switch v1.TaintEffect(effect) {
+	case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	default:
+		return nil, fmt.Errorf("invalid taint effect %q: must be NoSchedule, PreferNoSchedule or NoExecute", effect)
+	}
+	return &v1.Taint{Key: key, Value: value, Effect: v1.TaintEffect(effect)}, nil
+}
+
+// applyRebootTaint adds the taint to the node unless a taint with the same key and effect is
+// already there. Taints are a plain list the apiserver can't merge, so the node is read fresh
+// and updated, retrying on conflict.
+func applyRebootTaint(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, nodeName string, taint *v1.Taint, apiTimeout time.Duration, backoff wait.Backoff, dryRun bool) error {
+	return updateNodeTaints(ctx, logger, client, nodeName, apiTimeout, backoff, dryRun, func(taints []v1.Taint) ([]v1.Taint, bool) {
+		if taintIndex(taints, taint) >= 0 {
+			return taints, false
+		}
+		added := *taint
+		if added.Effect == v1.TaintEffectNoExecute {
+			added.TimeAdded = ptr.To(metav1.Now())
+		}
+		return append(taints, added), true
+	})
+}
+
+// removeRebootTaint removes the taint from the node if it is there
+func removeRebootTaint(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, nodeName string, taint *v1.Taint, apiTimeout time.Duration, backoff wait.Backoff, dryRun bool) error {
+	return updateNodeTaints(ctx, logger, client, nodeName, apiTimeout, backoff, dryRun, func(taints []v1.Taint) ([]v1.Taint, bool) {
+		i := taintIndex(taints, taint)
+		if i < 0 {
+			return taints, false
+		}
+		return append(taints[:i:i], taints[i+1:]...), true
+	})
+}
+
+// Helper function to check whether the node carries the taint
+func hasTaint(node *v1.Node, taint *v1.Taint) bool {
+	return taintIndex(node.Spec.Taints, taint) >= 0
+}
+
+// Helper function to find a taint by key and effect, the same way kubectl taint matches them
+func taintIndex(taints []v1.Taint, taint *v1.Taint) int {
+	for i := range taints {
+		if taints[i].Key == taint.Key && taints[i].Effect == taint.Effect {
+			return i
+		}
+	}
+	return -1
+}
+
+// Helper function to read the node, change its taints and write it back, retrying on conflict.
+// change reports whether it changed anything; if not the update is skipped.
+func updateNodeTaints(ctx context.Context, logger *slog.Logger, client kubernetes.Interface, nodeName string, apiTimeout time.Duration, backoff wait.Backoff, dryRun bool, change func([]v1.Taint) ([]v1.Taint, bool)) error {
+	return retry.RetryOnConflict(backoff, func() error {
+		getCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+		node, err := client.CoreV1().Nodes().Get(getCtx, nodeName, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return err
+		}
+		taints, changed := change(node.Spec.Taints)
+		if !changed {
+			return nil
+		}
+		if dryRun {
+			logger.Info("Dry run: would update node taints", "taints", taints)
+			return nil
+		}
+		node.Spec.Taints = taints
+		updateCtx, cancel := context.WithTimeout(ctx, apiTimeout)
+		defer cancel()
+		_, err = client.CoreV1().Nodes().Update(updateCtx, node, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/retry"
+)
+
+func TestRebootTaint(t *testing.T) {
+	taint, err := parseTaint("reboot-agent/rebooting=true:NoExecute")
+	if err != nil {
+		t.Fatalf("parseTaint() failed: %v", err)
+	}
+	node := testNode("node-1", nil)
+	other := v1.Taint{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule}
+	node.Spec.Taints = []v1.Taint{other}
+	client := fake.NewSimpleClientset(node)
+	taints := func() []v1.Taint {
+		t.Helper()
+		node, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node.Spec.Taints
+	}
+
+	// Added once, however often the node is handled
+	for i := 0; i < 2; i++ {
+		if err := applyRebootTaint(context.Background(), discardLogger(), client, "node-1", taint, time.Second, retry.DefaultBackoff, false); err != nil {
+			t.Fatalf("applyRebootTaint() failed: %v", err)
+		}
+	}
+	got := taints()
+	if len(got) != 2 || got[0].Key != other.Key || got[1].Key != taint.Key || got[1].Value != "true" || got[1].Effect != v1.TaintEffectNoExecute {
+		t.Fatalf("taints = %+v, want %s kept and the reboot taint added once", got, other.Key)
+	}
+	if got[1].TimeAdded == nil {
+		t.Error("NoExecute taint added without TimeAdded")
+	}
+
+	// Removed without touching the other taint, and removing it again is a no-op
+	for i := 0; i < 2; i++ {
+		if err := removeRebootTaint(context.Background(), discardLogger(), client, "node-1", taint, time.Second, retry.DefaultBackoff, false); err != nil {
+			t.Fatalf("removeRebootTaint() failed: %v", err)
+		}
+	}
+	if got := taints(); len(got) != 1 || got[0].Key != other.Key {
+		t.Errorf("taints after removal = %+v, want only %s", got, other.Key)
+	}
+	updates := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			updates++
+		}
+	}
+	if updates != 2 {
+		t.Errorf("node updated %d times, want once to add and once to remove", updates)
+	}
+}
+
+func TestParseTaint(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    v1.Taint
+		wantErr bool
+	}{
+		{"reboot-agent/rebooting=true:NoExecute", v1.Taint{Key: "reboot-agent/rebooting", Value: "true", Effect: v1.TaintEffectNoExecute}, false},
+		{"rebooting:NoSchedule", v1.Taint{Key: "rebooting", Effect: v1.TaintEffectNoSchedule}, false},
+		{"rebooting=true", v1.Taint{}, true},
+		{"rebooting=true:Evict", v1.Taint{}, true},
+		{"not a key:NoSchedule", v1.Taint{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTaint(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTaint(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseTaint(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+}
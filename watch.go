@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchHealth tracks watch failures per informer. An informer whose watch failed threshold
+// times within window is considered broken, which fails the health probes so the kubelet
+// restarts the agent with fresh connections.
+type watchHealth struct {
+	threshold int // 0 never fails the probes
+	window    time.Duration
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	// Resource version each informer's reflector had reached at its last failure, to tell
+	// a dropped watch from a retry that failed again
+	failedAt map[string]string
+}
+
+func newWatchHealth(threshold int, window time.Duration) *watchHealth {
+	return &watchHealth{threshold: threshold, window: window, failures: make(map[string][]time.Time), failedAt: make(map[string]string)}
+}
+
+// handler returns a watch error handler for the named informer. The reflector calls it once
+// per failed list or watch, right before backing off and re-listing. Every call is logged,
+// counted in watch_errors_total and recorded, but only a failure after the reflector synced
+// again since the previous one is counted as a reconnect: while the apiserver stays
+// unreachable, each retry's list fails too and nothing is re-established.
+func (h *watchHealth) handler(logger *slog.Logger, name string) cache.WatchErrorHandler {
+	return func(r *cache.Reflector, err error) {
+		logger.Warn("Watch dropped, reconnecting", "informer", name, "type", r.TypeDescription(), "error", err)
+		watchErrorsTotal.WithLabelValues(name).Inc()
+		if h.relisted(name, r.LastSyncResourceVersion()) {
+			watchReconnectsTotal.WithLabelValues(name).Inc()
+		}
+		h.record(name, time.Now())
+		cache.DefaultWatchErrorHandler(r, err)
+	}
+}
+
+// Helper function to record the resource version an informer failed at and report whether
+// its reflector had listed successfully since its previous failure
+func (h *watchHealth) relisted(name, resourceVersion string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	previous, failedBefore := h.failedAt[name]
+	h.failedAt[name] = resourceVersion
+	if !failedBefore {
+		return resourceVersion != ""
+	}
+	return resourceVersion != previous
+}
+
+// Helper function to record a watch failure
+func (h *watchHealth) record(name string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures[name] = append(h.recent(name, now), now)
+}
+
+// healthy reports whether every informer's watch failed fewer than threshold times within
+// the window, and if not, which informer is failing
+func (h *watchHealth) healthy() (bool, string) {
+	if h.threshold == 0 {
+		return true, ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for name := range h.failures {
+		h.failures[name] = h.recent(name, now)
+		if len(h.failures[name]) >= h.threshold {
+			return false, name
+		}
+	}
+	return true, ""
+}
+
+// Helper function to drop an informer's failures older than the window. Must hold mu.
+func (h *watchHealth) recent(name string, now time.Time) []time.Time {
+	failures := h.failures[name]
+	for len(failures) > 0 && now.Sub(failures[0]) > h.window {
+		failures = failures[1:]
+	}
+	return failures
+}
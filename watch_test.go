@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Helper function to build a reflector to hand to a watch error handler
+func newTestReflector() *cache.Reflector {
+	return cache.NewReflector(&cache.ListWatch{}, &v1.Node{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+}
+
+// Helper function to build a reflector that listed nodes at resourceVersion, then lost its watch
+func newListedReflector(t *testing.T, resourceVersion string) *cache.Reflector {
+	t.Helper()
+	lw := &cache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+			return &v1.NodeList{ListMeta: metav1.ListMeta{ResourceVersion: resourceVersion}}, nil
+		},
+		WatchFunc: func(metav1.ListOptions) (watch.Interface, error) {
+			return nil, apierrors.NewResourceExpired("too old resource version")
+		},
+	}
+	r := cache.NewReflector(lw, &v1.Node{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+	if err := r.ListAndWatch(make(chan struct{})); err == nil {
+		t.Fatal("ListAndWatch() succeeded, want the watch to fail")
+	}
+	return r
+}
+
+func TestWatchHandlerCountsReconnects(t *testing.T) {
+	watches := newWatchHealth(0, 0)
+	handler := watches.handler(discardLogger(), "test-reconnects")
+	reconnects := func() float64 {
+		return testutil.ToFloat64(watchReconnectsTotal.WithLabelValues("test-reconnects"))
+	}
+	errs := func() float64 {
+		return testutil.ToFloat64(watchErrorsTotal.WithLabelValues("test-reconnects"))
+	}
+	beforeReconnects, beforeErrors := reconnects(), errs()
+
+	// The initial list failing never established anything to reconnect
+	handler(newTestReflector(), errors.New("connection refused"))
+	// The watch dropping after a successful list is a reconnect, but the retries failing
+	// again before the reflector lists successfully aren't
+	listed := newListedReflector(t, "10")
+	handler(listed, errors.New("connection reset"))
+	handler(listed, errors.New("connection refused"))
+	handler(listed, errors.New("connection refused"))
+	// Once it listed again, the next drop is another reconnect
+	handler(newListedReflector(t, "12"), errors.New("connection reset"))
+
+	if got := reconnects() - beforeReconnects; got != 2 {
+		t.Errorf("reboot_agent_watch_reconnects_total grew by %v, want 2", got)
+	}
+	if got := errs() - beforeErrors; got != 5 {
+		t.Errorf("watch_errors_total grew by %v, want 5", got)
+	}
+}
+
+func TestWatchHealthThreshold(t *testing.T) {
+	watches := newWatchHealth(2, time.Minute)
+	handler := watches.handler(discardLogger(), "test-errors")
+	before := testutil.ToFloat64(watchErrorsTotal.WithLabelValues("test-errors"))
+
+	handler(newTestReflector(), errors.New("connection refused"))
+	if healthy, _ := watches.healthy(); !healthy {
+		t.Error("unhealthy after one failure, below the threshold of 2")
+	}
+	handler(newTestReflector(), errors.New("connection refused"))
+	if healthy, informer := watches.healthy(); healthy || informer != "test-errors" {
+		t.Errorf("healthy() = %v, %q after two failures, want false naming test-errors", healthy, informer)
+	}
+	if got := testutil.ToFloat64(watchErrorsTotal.WithLabelValues("test-errors")) - before; got != 2 {
+		t.Errorf("reboot_agent_watch_errors_total grew by %v, want 2", got)
+	}
+
+	// Failures older than the window no longer count
+	watches = newWatchHealth(2, time.Minute)
+	watches.record("nodes", time.Now().Add(-2*time.Minute))
+	watches.record("nodes", time.Now().Add(-90*time.Second))
+	if healthy, _ := watches.healthy(); !healthy {
+		t.Error("unhealthy from failures outside the window")
+	}
+	watches.record("nodes", time.Now())
+	if healthy, _ := watches.healthy(); !healthy {
+		t.Error("unhealthy from one recent failure once the old ones expired")
+	}
+
+	// A zero threshold never fails the probes
+	watches = newWatchHealth(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		watches.record("nodes", time.Now())
+	}
+	if healthy, _ := watches.healthy(); !healthy {
+		t.Error("unhealthy with the threshold disabled")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a daily time range, optionally limited to some days of the week, during
+// which reboots may start. A range whose end is not after its start wraps past midnight and
+// belongs to the day it starts on, so "22:00-02:00,fri" covers Friday 22:00 to Saturday 02:00.
+type MaintenanceWindow struct {
+	start, end time.Duration // Offsets from midnight
+	days       [7]bool       // Indexed by time.Weekday
+	location   *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseMaintenanceWindow parses a "HH:MM-HH:MM[,days...]" spec, where each day is a name
+// (mon) or a range (mon-fri). Without days the window applies every day. Times are read in loc.
+func parseMaintenanceWindow(spec string, loc *time.Location) (*MaintenanceWindow, error) {
+	parts := strings.Split(spec, ",")
+	from, to, ok := strings.Cut(strings.TrimSpace(parts[0]), "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid maintenance window %q: expected HH:MM-HH:MM[,days]", spec)
+	}
+	w := &MaintenanceWindow{location: loc}
+	var err error
+	if w.start, err = parseTimeOfDay(from); err != nil {
+		return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	if w.end, err = parseTimeOfDay(to); err != nil {
+		return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+
+	if len(parts) == 1 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+		return w, nil
+	}
+	for _, day := range parts[1:] {
+		first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(day)), "-")
+		if !isRange {
+			last = first
+		}
+		firstDay, ok1 := weekdays[first]
+		lastDay, ok2 := weekdays[last]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid maintenance window %q: unknown day %q", spec, day)
+		}
+		// Ranges may wrap around the week, e.g. fri-mon
+		for d := firstDay; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == lastDay {
+				break
+			}
+		}
+	}
+	return w, nil
+}
+
+// Helper function to parse HH:MM into an offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Allows reports whether t falls inside the window. A nil window allows any time.
+func (w *MaintenanceWindow) Allows(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	t = t.In(w.location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+
+	if w.start < w.end {
+		return w.days[day] && offset >= w.start && offset < w.end
+	}
+	// Wraps past midnight: the late part belongs to today, the early part to yesterday's window
+	if offset >= w.start {
+		return w.days[day]
+	}
+	return offset < w.end && w.days[(day+6)%7]
+}
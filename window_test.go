@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowAllows(t *testing.T) {
+	// 2024-01-05 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{"09:00-17:00", at(5, 12, 0), true},
+		{"09:00-17:00", at(5, 8, 59), false},
+		{"09:00-17:00", at(5, 9, 0), true},
+		{"09:00-17:00", at(5, 17, 0), false},
+		{"09:00-17:00,mon-fri", at(7, 12, 0), false}, // Sunday
+		{"09:00-17:00,fri-mon", at(7, 12, 0), true},  // Range wrapping the week
+		{"09:00-17:00,fri-mon", at(3, 12, 0), false}, // Wednesday
+		// Wrapping past midnight, the early hours belong to the day the window opened
+		{"22:00-02:00,fri", at(5, 21, 59), false},
+		{"22:00-02:00,fri", at(5, 22, 0), true},
+		{"22:00-02:00,fri", at(5, 23, 59), true},
+		{"22:00-02:00,fri", at(6, 0, 0), true},
+		{"22:00-02:00,fri", at(6, 1, 59), true},
+		{"22:00-02:00,fri", at(6, 2, 0), false},
+		{"22:00-02:00,fri", at(5, 1, 0), false}, // Thursday's window
+		{"22:00-02:00,fri", at(6, 22, 0), false},
+		{"00:00-00:00", at(5, 3, 0), true}, // All day
+	}
+	for _, tt := range tests {
+		window, err := parseMaintenanceWindow(tt.spec, time.UTC)
+		if err != nil {
+			t.Fatalf("parseMaintenanceWindow(%q) failed: %v", tt.spec, err)
+		}
+		if got := window.Allows(tt.at); got != tt.want {
+			t.Errorf("%q allows %s = %v, want %v", tt.spec, tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestMaintenanceWindowTimezone(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	window, err := parseMaintenanceWindow("22:00-02:00,thu", est)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Friday 03:30 UTC is Thursday 22:30 in EST
+	if !window.Allows(time.Date(2024, 1, 5, 3, 30, 0, 0, time.UTC)) {
+		t.Error("window not read in its timezone")
+	}
+	if window.Allows(time.Date(2024, 1, 4, 22, 30, 0, 0, time.UTC)) {
+		t.Error("window read in UTC")
+	}
+
+	var none *MaintenanceWindow
+	if !none.Allows(time.Now()) {
+		t.Error("no window should allow any time")
+	}
+}
+
+func TestParseMaintenanceWindowInvalid(t *testing.T) {
+	for _, spec := range []string{"", "22:00", "25:00-02:00", "22:00-2am", "22:00-02:00,someday", "22:00-02:00,mon-xyz"} {
+		if _, err := parseMaintenanceWindow(spec, time.UTC); err == nil {
+			t.Errorf("parseMaintenanceWindow(%q) succeeded, want an error", spec)
+		}
+	}
+}